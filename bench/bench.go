@@ -0,0 +1,171 @@
+// Package bench measures how fast connections are accepted by a single
+// listener compared to a Sharder with several SO_REUSEPORT listeners.
+//
+// The benchmarks are plain functions so they can be run by cmd/bench with
+// testing.Benchmark; bench_test.go wraps them for go test -bench.
+package bench
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/douglasmakey/socket-sharding/reuseport"
+)
+
+// ShardCounts are the shard counts exercised by BenchmarkShardedAccept and
+// cmd/bench.
+var ShardCounts = []int{1, 2, 4, 8}
+
+// Clients is the number of goroutines dialing concurrently.
+var Clients = 64
+
+// Single measures accepts on a single plain listener.
+func Single(b *testing.B) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	run(b, []net.Listener{l})
+}
+
+// Sharded returns a benchmark measuring accepts on a Sharder with n shards.
+func Sharded(n int) func(b *testing.B) {
+	return func(b *testing.B) {
+		s, err := reuseport.NewSharder(n, "127.0.0.1:0")
+		if err != nil {
+			b.Fatal(err)
+		}
+		defer s.Shutdown(context.Background())
+		run(b, s.Listeners())
+	}
+}
+
+// Locked returns a benchmark measuring accepts on a Sharder with n shards
+// and WithCPUAffinity, running its own accept loops with Sharder.AcceptLoop,
+// and with WithLockedThreads if locked is set. Compare the latency metric
+// more than the throughput: pinning targets the former.
+func Locked(n int, locked bool) func(b *testing.B) {
+	return func(b *testing.B) {
+		opts := []reuseport.SharderOption{reuseport.WithCPUAffinity()}
+		if locked {
+			opts = append(opts, reuseport.WithLockedThreads())
+		}
+		s, err := reuseport.NewSharder(n, "127.0.0.1:0", opts...)
+		if err != nil {
+			b.Fatal(err)
+		}
+		total := int64(b.N)
+
+		var accepted atomic.Int64
+		done := make(chan struct{})
+		loop := make(chan error, 1)
+		go func() {
+			loop <- s.AcceptLoop(context.Background(), func(c net.Conn) {
+				c.Close()
+				if accepted.Add(1) == total {
+					close(done)
+				}
+			})
+		}()
+
+		latency, err := dial(b, s.Addr().String(), total, done)
+		s.Close()
+		if err == nil {
+			err = <-loop
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+		report(b, latency)
+	}
+}
+
+// run accepts b.N connections dialed by Clients goroutines, spread over the
+// listeners ls which all share one address, and closes ls before returning.
+func run(b *testing.B, ls []net.Listener) {
+	total := int64(b.N)
+
+	var accepted atomic.Int64
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, l := range ls {
+		wg.Add(1)
+		go func(l net.Listener) {
+			defer wg.Done()
+			for {
+				c, err := l.Accept()
+				if err != nil {
+					return
+				}
+				c.Close()
+				if accepted.Add(1) == total {
+					close(done)
+				}
+			}
+		}(l)
+	}
+
+	latency, err := dial(b, ls[0].Addr().String(), total, done)
+	for _, l := range ls {
+		l.Close()
+	}
+	wg.Wait()
+
+	if err != nil {
+		b.Fatal(err)
+	}
+	report(b, latency)
+}
+
+// dial opens total connections to addr from Clients goroutines and times them
+// until done is closed, which the accepting side does once it has accepted
+// them all. It returns the mean time from dialing a connection to seeing it
+// closed by the server.
+//
+// Clients wait for the server to close each connection and then reset it, so
+// that no socket is left in TIME_WAIT between iterations.
+func dial(b *testing.B, addr string, total int64, done <-chan struct{}) (time.Duration, error) {
+	b.ResetTimer()
+	var remaining, elapsed, conns atomic.Int64
+	remaining.Store(total)
+	errc := make(chan error, Clients)
+	for i := 0; i < Clients; i++ {
+		go func() {
+			for remaining.Add(-1) >= 0 {
+				start := time.Now()
+				c, err := net.Dial("tcp", addr)
+				if err != nil {
+					errc <- err
+					return
+				}
+				io.Copy(io.Discard, c)
+				elapsed.Add(int64(time.Since(start)))
+				conns.Add(1)
+				c.(*net.TCPConn).SetLinger(0)
+				c.Close()
+			}
+		}()
+	}
+
+	var err error
+	select {
+	case <-done:
+	case err = <-errc:
+	}
+	b.StopTimer()
+
+	if n := conns.Load(); n > 0 {
+		return time.Duration(elapsed.Load() / n), err
+	}
+	return 0, err
+}
+
+func report(b *testing.B, latency time.Duration) {
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "accepts/s")
+	b.ReportMetric(float64(latency)/float64(time.Microsecond), "µs/conn")
+}
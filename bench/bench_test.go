@@ -0,0 +1,27 @@
+package bench
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+func BenchmarkSingleAccept(b *testing.B) {
+	Single(b)
+}
+
+// BenchmarkShardedAccept runs Sharded as a sub-benchmark for every entry of
+// ShardCounts.
+func BenchmarkShardedAccept(b *testing.B) {
+	for _, n := range ShardCounts {
+		b.Run(fmt.Sprintf("shards=%d", n), Sharded(n))
+	}
+}
+
+// BenchmarkLockedThreads runs Locked as sub-benchmarks with one shard per
+// CPU, without and with WithLockedThreads.
+func BenchmarkLockedThreads(b *testing.B) {
+	n := runtime.NumCPU()
+	b.Run("unlocked", Locked(n, false))
+	b.Run("locked", Locked(n, true))
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/douglasmakey/socket-sharding/bench"
+)
+
+func main() {
+	fmt.Printf("%-12s %s\n", "single", testing.Benchmark(bench.Single))
+	for _, n := range bench.ShardCounts {
+		fmt.Printf("%-12s %s\n", fmt.Sprintf("shards=%d", n), testing.Benchmark(bench.Sharded(n)))
+	}
+	n := runtime.NumCPU()
+	fmt.Printf("%-12s %s\n", "unlocked", testing.Benchmark(bench.Locked(n, false)))
+	fmt.Printf("%-12s %s\n", "locked", testing.Benchmark(bench.Locked(n, true)))
+}
@@ -1,5 +0,0 @@
-module github.com/douglasmakey/socket-sharding/cmd/http-example
-
-go 1.16
-
-require golang.org/x/sys v0.0.0-20210630005230-0f9fa26af87c
@@ -2,39 +2,81 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"runtime"
 	"syscall"
+	"time"
 
-	"golang.org/x/sys/unix"
+	"github.com/douglasmakey/socket-sharding/reuseport"
 )
 
-var lc = net.ListenConfig{
-	Control: func(network, address string, c syscall.RawConn) error {
-		var opErr error
-		if err := c.Control(func(fd uintptr) {
-			opErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
-		}); err != nil {
-			return err
-		}
-		return opErr
-	},
-}
-
 func main() {
 	pid := os.Getpid()
-	l, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:8080")
+	s, err := reuseport.NewSharder(runtime.NumCPU(), "127.0.0.1:8080")
 	if err != nil {
 		panic(err)
 	}
-	server := &http.Server{}
+
+	// kill -HUP <pid> replaces the shard sockets without dropping the port.
+	// With net.ipv4.tcp_migrate_req=1 no connection is dropped either.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := s.Reload(); err != nil {
+				fmt.Printf("HTTP Server with PID: %d failed to reload: %v \n", pid, err)
+				continue
+			}
+			fmt.Printf("HTTP Server with PID: %d reloaded \n", pid)
+		}
+	}()
+
+	// kill -TERM <pid> shuts down gracefully: /readyz answers 503 first, so
+	// that load balancers stop routing here while the port still accepts,
+	// then the connections in flight get up to 30 seconds to finish.
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGTERM, os.Interrupt)
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-term
+		fmt.Printf("HTTP Server with PID: %d is not ready anymore \n", pid)
+		s.MarkNotReady()
+		time.Sleep(5 * time.Second)
+
+		fmt.Printf("HTTP Server with PID: %d is shutting down \n", pid)
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			fmt.Printf("HTTP Server with PID: %d failed to shut down: %v \n", pid, err)
+			return
+		}
+		fmt.Printf("HTTP Server with PID: %d shut down \n", pid)
+	}()
+
+	health := reuseport.HealthHandler(s)
+	http.Handle("/healthz", health)
+	http.Handle("/readyz", health)
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		fmt.Fprintf(w, "Hello from PID %d \n", pid)
 	})
 
 	fmt.Printf("HTTP Server with PID: %d is running \n", pid)
-	panic(server.Serve(l))
+	server := &http.Server{
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      10 * time.Second,
+		IdleTimeout:       time.Minute,
+	}
+	if err := s.ServeWithServer(server, http.DefaultServeMux); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		panic(err)
+	}
+	// Serve returns as soon as Shutdown starts, so wait for the connections in
+	// flight before exiting.
+	<-shutdown
 }
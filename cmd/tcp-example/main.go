@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"runtime"
+	"sync"
+
+	"github.com/douglasmakey/socket-sharding/reuseport"
+)
+
+func main() {
+	pid := os.Getpid()
+	s, err := reuseport.NewSharder(runtime.NumCPU(), "127.0.0.1:9090")
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	var wg sync.WaitGroup
+	for i, l := range s.Listeners() {
+		wg.Add(1)
+		go func(i int, l net.Listener) {
+			defer wg.Done()
+			err := reuseport.AcceptLoop(ctx, l, func(c net.Conn) {
+				defer c.Close()
+				fmt.Fprintf(c, "Hello from PID %d shard %d \n", pid, i)
+				io.Copy(c, c)
+			})
+			if err != nil {
+				fmt.Printf("shard %d: %v \n", i, err)
+			}
+		}(i, l)
+	}
+
+	fmt.Printf("TCP echo server with PID: %d is running with %d shards \n", pid, len(s.Listeners()))
+	wg.Wait()
+	s.Shutdown(context.Background())
+}
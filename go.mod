@@ -0,0 +1,5 @@
+module github.com/douglasmakey/socket-sharding
+
+go 1.20
+
+require golang.org/x/sys v0.0.0-20210630005230-0f9fa26af87c
@@ -0,0 +1,115 @@
+package reuseport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// deadlineListener is implemented by *net.TCPListener, *net.UnixListener and
+// the shard listeners of a Sharder.
+type deadlineListener interface {
+	net.Listener
+	SetDeadline(t time.Time) error
+}
+
+// AcceptContext waits for the next connection on l like l.Accept, but returns
+// ctx.Err() as soon as ctx is done.
+//
+// If l has a SetDeadline method, as TCP and Unix listeners do, the pending
+// Accept is interrupted by moving its deadline to the past and the deadline
+// is cleared again before returning, so l stays open and usable; any deadline
+// set on l beforehand is lost. Other listeners are closed to interrupt the
+// Accept and cannot be used afterwards. A connection accepted at the same
+// time ctx is done is returned rather than dropped.
+func AcceptContext(ctx context.Context, l net.Listener) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	stop := interruptOnDone(ctx, l)
+	c, err := l.Accept()
+	stop()
+
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return c, err
+}
+
+// AcceptLoop accepts connections from l and calls handle in a new goroutine
+// for each of them, until ctx is done or l is closed or drained, in which
+// case it returns nil. Temporary accept errors, such as running out of file
+// descriptors, are retried with an increasing delay of up to one second; any
+// other error is returned.
+//
+// AcceptLoop does not wait for the handlers to return. When ctx is done, l is
+// left open as described for AcceptContext.
+func AcceptLoop(ctx context.Context, l net.Listener, handle func(net.Conn)) error {
+	return acceptLoop(ctx, l, func(c net.Conn) {
+		go handle(c)
+	})
+}
+
+// acceptLoop is AcceptLoop with handle called in the accepting goroutine.
+func acceptLoop(ctx context.Context, l net.Listener, handle func(net.Conn)) error {
+	stop := interruptOnDone(ctx, l)
+	defer stop()
+
+	var delay time.Duration
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) || errors.Is(err, ErrDraining) {
+				return nil
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Temporary() {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					return nil
+				}
+				continue
+			}
+			return err
+		}
+		delay = 0
+		handle(c)
+	}
+}
+
+// interruptOnDone interrupts any pending Accept on l once ctx is done, by
+// moving its deadline to the past or, if l has no deadline, closing it. The
+// returned function stops watching ctx and clears the deadline again.
+func interruptOnDone(ctx context.Context, l net.Listener) (stop func()) {
+	dl, hasDeadline := l.(deadlineListener)
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			if hasDeadline {
+				dl.SetDeadline(time.Unix(1, 0))
+			} else {
+				l.Close()
+			}
+		case <-quit:
+		}
+	}()
+
+	return func() {
+		close(quit)
+		<-done
+		if hasDeadline && ctx.Err() != nil {
+			dl.SetDeadline(time.Time{})
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package reuseport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAcceptContextTimeout(t *testing.T) {
+	s, err := NewSharder(1, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	l := s.Listeners()[0]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	c, err := AcceptContext(ctx, l)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("AcceptContext = %v, %v, want context.DeadlineExceeded", c, err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("AcceptContext returned after %v", d)
+	}
+
+	// The shard listener is still open and accepts without a deadline.
+	go func() {
+		if c, err := net.Dial("tcp", l.Addr().String()); err == nil {
+			c.Close()
+		}
+	}()
+	c, err = l.Accept()
+	if err != nil {
+		t.Fatalf("Accept after AcceptContext: %v", err)
+	}
+	c.Close()
+}
+
+func TestAcceptContextCanceled(t *testing.T) {
+	l, err := Listen(context.Background(), "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := AcceptContext(ctx, l); !errors.Is(err, context.Canceled) {
+		t.Fatalf("AcceptContext = %v, want context.Canceled", err)
+	}
+}
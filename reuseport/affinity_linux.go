@@ -0,0 +1,43 @@
+package reuseport
+
+import (
+	"fmt"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockThread locks the calling goroutine to its OS thread and pins the thread
+// to cpu. The goroutine must never unlock it: when it exits still locked, the
+// runtime terminates the thread instead of handing it to other goroutines
+// with the affinity left over.
+func lockThread(cpu int) error {
+	runtime.LockOSThread()
+	var set unix.CPUSet
+	set.Set(cpu)
+	if err := unix.SchedSetaffinity(0, &set); err != nil {
+		return fmt.Errorf("reuseport: pin thread to CPU %d: %w", cpu, err)
+	}
+	return nil
+}
+
+// allowedCPUs returns the CPUs the calling thread may run on, in increasing
+// order, which are not 0 to runtime.NumCPU()-1 when the process is confined
+// to a cpuset. If the affinity mask cannot be read, it assumes those.
+func allowedCPUs() []int {
+	var set unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &set); err != nil || set.Count() == 0 {
+		cpus := make([]int, runtime.NumCPU())
+		for i := range cpus {
+			cpus[i] = i
+		}
+		return cpus
+	}
+	cpus := make([]int, 0, set.Count())
+	for cpu := 0; len(cpus) < cap(cpus); cpu++ {
+		if set.IsSet(cpu) {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus
+}
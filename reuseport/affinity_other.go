@@ -0,0 +1,18 @@
+//go:build !linux
+
+package reuseport
+
+import "runtime"
+
+func lockThread(cpu int) error {
+	return linuxOnly("sched_setaffinity")
+}
+
+// allowedCPUs returns the CPUs 0 to runtime.NumCPU()-1.
+func allowedCPUs() []int {
+	cpus := make([]int, runtime.NumCPU())
+	for i := range cpus {
+		cpus[i] = i
+	}
+	return cpus
+}
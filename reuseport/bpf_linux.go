@@ -0,0 +1,77 @@
+package reuseport
+
+import (
+	"errors"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// Ancillary data offsets of classic BPF, from linux/filter.h. SKF_AD_OFF is
+// -0x1000 as a 32-bit offset.
+const (
+	skfAdOff = 0xfffff000
+	skfAdCPU = 36
+)
+
+// SockFilter is a classic BPF instruction. It is unix.SockFilter on Linux, so
+// programs built with golang.org/x/sys/unix can be passed as they are.
+type SockFilter = unix.SockFilter
+
+// WithReusePortBPF attaches the classic BPF program prog to the reuseport
+// group of the socket, with SO_ATTACH_REUSEPORT_CBPF. The program returns the
+// index, in bind order, of the socket that receives each connection; if it
+// returns an index out of range the kernel falls back to its default hash.
+// Closing a socket of the group moves the last socket into its index; see
+// Sharder.DrainShard and Sharder.Reload.
+//
+// The program belongs to the whole group, so only one socket needs it. Pass
+// the option to a single Listen call of the group; NewSharder attaches it to
+// shard 0 only.
+func WithReusePortBPF(prog []SockFilter) ListenOption {
+	return listenOption(func(o *options) {
+		o.attachBPF = func(l net.Listener) error {
+			return AttachReusePortCBPF(l, prog)
+		}
+	})
+}
+
+// AttachReusePortCBPF attaches the classic BPF program prog to the reuseport
+// group of the already open listener l, replacing any program attached
+// before by any socket of the group.
+func AttachReusePortCBPF(l net.Listener, prog []SockFilter) error {
+	if len(prog) == 0 {
+		return errors.New("reuseport: empty BPF program")
+	}
+	fprog := unix.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+	return controlListener(l, func(fd uintptr) error {
+		return unix.SetsockoptSockFprog(int(fd), unix.SOL_SOCKET, unix.SO_ATTACH_REUSEPORT_CBPF, &fprog)
+	})
+}
+
+// AttachReusePortEBPF attaches the loaded eBPF program referenced by progFD,
+// of type BPF_PROG_TYPE_SOCKET_FILTER, to the reuseport group of the already
+// open listener l.
+func AttachReusePortEBPF(l net.Listener, progFD int) error {
+	return controlListener(l, func(fd uintptr) error {
+		return unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_ATTACH_REUSEPORT_EBPF, progFD)
+	})
+}
+
+// SteerByCPU returns a classic BPF program for a group of n sockets that
+// sends each connection to socket cpu % n, where cpu is the CPU that
+// processed the packet. Combined with one shard per CPU it keeps a
+// connection on the core that received it:
+//
+//	s, err := reuseport.NewSharder(runtime.NumCPU(), ":8080",
+//		reuseport.WithReusePortBPF(reuseport.SteerByCPU(runtime.NumCPU())))
+func SteerByCPU(n int) []SockFilter {
+	return []SockFilter{
+		// A = cpu
+		{Code: unix.BPF_LD | unix.BPF_W | unix.BPF_ABS, K: skfAdOff + skfAdCPU},
+		// A = A % n
+		{Code: unix.BPF_ALU | unix.BPF_MOD | unix.BPF_K, K: uint32(n)},
+		// return A
+		{Code: unix.BPF_RET | unix.BPF_A},
+	}
+}
@@ -0,0 +1,117 @@
+package reuseport
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// acceptingShard dials s and returns the index of the shard that accepts
+// the connection.
+func acceptingShard(t *testing.T, s *Sharder) int {
+	t.Helper()
+	c, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for i, l := range s.shards() {
+		l.SetDeadline(time.Now().Add(50 * time.Millisecond))
+		ac, err := l.Accept()
+		l.SetDeadline(time.Time{})
+		if err == nil {
+			ac.Close()
+			return i
+		}
+	}
+	t.Fatal("no shard accepted the connection")
+	return -1
+}
+
+func TestReloadKeepsBPFIndexes(t *testing.T) {
+	// Send every connection to the socket at group index 2.
+	prog := []SockFilter{{Code: unix.BPF_RET | unix.BPF_K, K: 2}}
+	s := newTestSharder(t, 4, WithReusePortBPF(prog))
+
+	if got := acceptingShard(t, s); got != 2 {
+		t.Fatalf("connection accepted by shard %d, want 2", got)
+	}
+	for i := 0; i < 2; i++ {
+		if err := s.Reload(); err != nil {
+			t.Fatal(err)
+		}
+		if got := acceptingShard(t, s); got != 2 {
+			t.Fatalf("after Reload %d, connection accepted by shard %d, want 2", i+1, got)
+		}
+	}
+}
+
+// loadReturnEBPF loads an eBPF socket filter that returns k and closes it at
+// the end of the test.
+func loadReturnEBPF(t *testing.T, k int32) int {
+	t.Helper()
+	type insn struct {
+		code uint8
+		regs uint8
+		off  int16
+		imm  int32
+	}
+	insns := []insn{
+		// r0 = k
+		{code: unix.BPF_ALU64 | unix.BPF_MOV | unix.BPF_K, imm: k},
+		// exit
+		{code: unix.BPF_JMP | unix.BPF_EXIT},
+	}
+	license := []byte("GPL\x00")
+	// The leading fields of union bpf_attr for BPF_PROG_LOAD.
+	attr := struct {
+		progType uint32
+		insnCnt  uint32
+		insns    uint64
+		license  uint64
+	}{
+		progType: unix.BPF_PROG_TYPE_SOCKET_FILTER,
+		insnCnt:  uint32(len(insns)),
+		insns:    uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+	}
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_LOAD, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr))
+	if errno != 0 {
+		if errors.Is(errno, unix.EPERM) {
+			t.Skip("loading eBPF programs is not permitted")
+		}
+		t.Fatalf("BPF_PROG_LOAD: %v", errno)
+	}
+	t.Cleanup(func() { unix.Close(int(fd)) })
+	return int(fd)
+}
+
+func TestAttachReusePortOnShard(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		attach func(t *testing.T, l net.Listener) error
+	}{
+		{"CBPF", func(t *testing.T, l net.Listener) error {
+			return AttachReusePortCBPF(l, []SockFilter{{Code: unix.BPF_RET | unix.BPF_K, K: 2}})
+		}},
+		{"EBPF", func(t *testing.T, l net.Listener) error {
+			return AttachReusePortEBPF(l, loadReturnEBPF(t, 2))
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestSharder(t, 4)
+			// Any socket of the group can attach the program.
+			if err := tt.attach(t, s.Listeners()[1]); err != nil {
+				t.Fatal(err)
+			}
+			if got := acceptingShard(t, s); got != 2 {
+				t.Fatalf("connection accepted by shard %d, want 2", got)
+			}
+		})
+	}
+}
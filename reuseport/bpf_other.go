@@ -0,0 +1,41 @@
+//go:build !linux
+
+package reuseport
+
+import "net"
+
+// SockFilter is a classic BPF instruction, laid out like struct sock_filter.
+type SockFilter struct {
+	Code uint16
+	Jt   uint8
+	Jf   uint8
+	K    uint32
+}
+
+// WithReusePortBPF attaches a classic BPF program to the reuseport group of
+// the socket. It is only supported on Linux: elsewhere the listen fails.
+func WithReusePortBPF(prog []SockFilter) ListenOption {
+	return listenOption(func(o *options) {
+		o.attachBPF = func(l net.Listener) error {
+			return AttachReusePortCBPF(l, prog)
+		}
+	})
+}
+
+// AttachReusePortCBPF attaches a classic BPF program to the reuseport group
+// of l. It is only supported on Linux.
+func AttachReusePortCBPF(l net.Listener, prog []SockFilter) error {
+	return linuxOnly("SO_ATTACH_REUSEPORT_CBPF")
+}
+
+// AttachReusePortEBPF attaches an eBPF program to the reuseport group of l.
+// It is only supported on Linux.
+func AttachReusePortEBPF(l net.Listener, progFD int) error {
+	return linuxOnly("SO_ATTACH_REUSEPORT_EBPF")
+}
+
+// SteerByCPU returns nil: steering by CPU with a BPF program is only
+// supported on Linux, and WithReusePortBPF fails on other platforms anyway.
+func SteerByCPU(n int) []SockFilter {
+	return nil
+}
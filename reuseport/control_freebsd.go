@@ -0,0 +1,20 @@
+package reuseport
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// setReusePort sets SO_REUSEPORT_LB on fd. On FreeBSD plain SO_REUSEPORT lets
+// several sockets bind the same address but delivers every connection to the
+// same one; SO_REUSEPORT_LB, available since FreeBSD 12, balances them across
+// the group like SO_REUSEPORT does on Linux. Older kernels reject it, in which
+// case SO_REUSEPORT is set instead so that binding still works.
+func setReusePort(fd uintptr) error {
+	err := unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT_LB, 1)
+	if errors.Is(err, unix.ENOPROTOOPT) {
+		return unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	}
+	return err
+}
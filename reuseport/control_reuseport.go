@@ -0,0 +1,13 @@
+//go:build linux || darwin || dragonfly || netbsd || openbsd
+
+package reuseport
+
+import "golang.org/x/sys/unix"
+
+// setReusePort sets SO_REUSEPORT on fd. Since Linux 3.9 the kernel balances
+// incoming connections and datagrams across every socket in the group. Darwin
+// and the other BSDs allow several sockets to bind the same address, but do
+// not balance connections across them the way Linux does.
+func setReusePort(fd uintptr) error {
+	return unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+}
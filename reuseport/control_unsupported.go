@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && !windows
+
+package reuseport
+
+import "errors"
+
+var errUnsupported = errors.New("reuseport: SO_REUSEPORT not supported on this platform")
+
+func setReusePort(fd uintptr) error {
+	return errUnsupported
+}
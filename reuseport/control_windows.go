@@ -0,0 +1,28 @@
+package reuseport
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// Windows has no SO_REUSEPORT; SO_REUSEADDR there lets any socket steal the
+// address, which is not the same thing. Failing at listen time keeps the
+// package buildable on Windows while making the limitation explicit.
+var errUnsupported = errors.New("reuseport: SO_REUSEPORT is not available on Windows")
+
+func setReusePort(fd uintptr) error {
+	return errUnsupported
+}
+
+// isAddrInUse reports whether err is a bind failing because the address is
+// already in use.
+func isAddrInUse(err error) bool {
+	return errors.Is(err, windows.WSAEADDRINUSE)
+}
+
+// isAddrNotAvail reports whether err is a bind failing because the address
+// is not available, such as an address not assigned to any interface yet.
+func isAddrNotAvail(err error) bool {
+	return errors.Is(err, windows.WSAEADDRNOTAVAIL)
+}
@@ -0,0 +1,15 @@
+//go:build !unix && !windows
+
+package reuseport
+
+// isAddrInUse reports whether err is a bind failing because the address is
+// already in use. The errors of this platform are not recognized.
+func isAddrInUse(err error) bool {
+	return false
+}
+
+// isAddrNotAvail reports whether err is a bind failing because the address
+// is not available. The errors of this platform are not recognized.
+func isAddrNotAvail(err error) bool {
+	return false
+}
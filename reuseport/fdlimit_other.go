@@ -0,0 +1,7 @@
+//go:build !unix
+
+package reuseport
+
+func checkFDLimit(n int, raise bool) error {
+	return nil
+}
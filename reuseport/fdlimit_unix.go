@@ -0,0 +1,36 @@
+//go:build unix
+
+package reuseport
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// fdHeadroom is the number of file descriptors left for the rest of the
+// process, such as the connections accepted by the shards, when checking
+// that n shards fit within RLIMIT_NOFILE.
+const fdHeadroom = 32
+
+// checkFDLimit returns an error if n listeners plus fdHeadroom would not fit
+// within the soft RLIMIT_NOFILE. If raise is set, the soft limit is first
+// raised to the hard limit.
+func checkFDLimit(n int, raise bool) error {
+	var lim unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &lim); err != nil {
+		return fmt.Errorf("reuseport: get RLIMIT_NOFILE: %w", err)
+	}
+	if raise && lim.Cur < lim.Max {
+		raised := lim
+		raised.Cur = raised.Max
+		if err := unix.Setrlimit(unix.RLIMIT_NOFILE, &raised); err != nil {
+			return fmt.Errorf("reuseport: raise RLIMIT_NOFILE from %d to %d: %w", lim.Cur, lim.Max, err)
+		}
+		lim = raised
+	}
+	if uint64(n)+fdHeadroom > uint64(lim.Cur) {
+		return fmt.Errorf("reuseport: %d shards need more file descriptors than the RLIMIT_NOFILE soft limit of %d allows, keeping %d spare", n, lim.Cur, fdHeadroom)
+	}
+	return nil
+}
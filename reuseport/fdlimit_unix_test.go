@@ -0,0 +1,111 @@
+//go:build unix
+
+package reuseport
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// testFDLimit is the soft RLIMIT_NOFILE set by lowerFDLimit.
+const testFDLimit = 64
+
+// lowerFDLimit sets the soft RLIMIT_NOFILE to testFDLimit for the duration
+// of the test.
+func lowerFDLimit(t *testing.T) {
+	t.Helper()
+	var lim unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &lim); err != nil {
+		t.Fatal(err)
+	}
+	if uint64(lim.Max) <= testFDLimit {
+		t.Skipf("hard RLIMIT_NOFILE of %d leaves no room to lower the soft limit to %d", lim.Max, testFDLimit)
+	}
+	lowered := lim
+	lowered.Cur = testFDLimit
+	if err := unix.Setrlimit(unix.RLIMIT_NOFILE, &lowered); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := unix.Setrlimit(unix.RLIMIT_NOFILE, &lim); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestNewSharderFDLimit(t *testing.T) {
+	lowerFDLimit(t)
+
+	// 40 shards plus fdHeadroom do not fit within testFDLimit descriptors.
+	s, err := NewSharder(40, "127.0.0.1:0")
+	if err == nil {
+		s.Close()
+		t.Fatal("NewSharder succeeded beyond RLIMIT_NOFILE")
+	}
+	if !strings.Contains(err.Error(), "RLIMIT_NOFILE") {
+		t.Fatalf("NewSharder = %v, want an RLIMIT_NOFILE error", err)
+	}
+
+	// Under WithMinShards, the check only covers the shards that must open.
+	s, err = NewSharder(40, "127.0.0.1:0", WithMinShards(8))
+	if s == nil {
+		t.Fatalf("NewSharder with WithMinShards(8) = %v", err)
+	}
+	s.Close()
+
+	s, err = NewSharder(40, "127.0.0.1:0", WithRaiseFDLimit())
+	if err != nil {
+		t.Fatalf("NewSharder with WithRaiseFDLimit = %v", err)
+	}
+	s.Close()
+}
+
+// openFDs returns the number of file descriptors open in the process.
+func openFDs(t *testing.T) int {
+	t.Helper()
+	fds, err := os.ReadDir("/dev/fd")
+	if err != nil {
+		t.Skip(err)
+	}
+	return len(fds)
+}
+
+func TestNewGroupPartialShards(t *testing.T) {
+	lowerFDLimit(t)
+	before := openFDs(t)
+
+	// The first address runs out of descriptors before opening every shard,
+	// which WithMinShards allows.
+	g, err := NewGroupWithOptions(testFDLimit, []string{"127.0.0.1:0"}, WithMinShards(4))
+	if g == nil {
+		t.Fatalf("NewGroupWithOptions = %v", err)
+	}
+	if !errors.Is(err, ErrPartialShards) {
+		t.Errorf("NewGroupWithOptions = %v, want ErrPartialShards", err)
+	}
+	if n := g.Sharder("127.0.0.1:0").Len(); n < 4 || n >= testFDLimit {
+		t.Errorf("opened %d shards, want a partial group", n)
+	}
+	if err := g.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if after := openFDs(t); after != before {
+		t.Errorf("%d file descriptors open after Shutdown, want %d", after, before)
+	}
+
+	// The second address cannot open a single shard, so the partial
+	// Sharder of the first one must be closed along with the group.
+	g, err = NewGroupWithOptions(testFDLimit, []string{"127.0.0.1:0", "127.0.0.2:0"}, WithMinShards(4))
+	if err == nil {
+		g.Shutdown(context.Background())
+		t.Fatal("NewGroupWithOptions succeeded without descriptors for the second address")
+	}
+	if after := openFDs(t); after != before {
+		t.Errorf("%d file descriptors open after a failed NewGroupWithOptions, want %d", after, before)
+	}
+}
@@ -0,0 +1,204 @@
+package reuseport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// Group manages one Sharder per address, for services that listen on several
+// addresses, such as :8080 and :8443, and want all of them sharded.
+type Group struct {
+	addrs    []string
+	sharders map[string]*Sharder
+	workers  int
+
+	mu      sync.Mutex
+	handled chan struct{}
+}
+
+// NewGroup opens a Sharder with the given number of shards on every address
+// in addrs. If any of them fails to open, the ones already opened are closed
+// and the error is returned.
+func NewGroup(shards int, addrs ...string) (*Group, error) {
+	return NewGroupWithOptions(shards, addrs)
+}
+
+// NewGroupWithOptions is like NewGroup, but opens every Sharder with opts,
+// as NewSharder does. WithWorkers sizes the single pool of workers shared by
+// all the addresses in Group.Handle. Under WithMinShards, the Sharders that
+// open with fewer shards than requested are kept, and the Group is returned
+// along with their ErrPartialShards errors, which should be treated as
+// warnings.
+func NewGroupWithOptions(shards int, addrs []string, opts ...SharderOption) (*Group, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("reuseport: no address for group")
+	}
+
+	g := &Group{
+		sharders: make(map[string]*Sharder, len(addrs)),
+		workers:  newSharderOptions(opts).workers,
+	}
+	var warnings []error
+	for _, addr := range addrs {
+		if _, ok := g.sharders[addr]; ok {
+			g.Shutdown(context.Background())
+			return nil, fmt.Errorf("reuseport: duplicate group address %s", addr)
+		}
+		s, err := NewSharder(shards, addr, opts...)
+		if s == nil {
+			g.Shutdown(context.Background())
+			return nil, fmt.Errorf("reuseport: open %s: %w", addr, err)
+		}
+		if err != nil {
+			warnings = append(warnings, fmt.Errorf("reuseport: open %s: %w", addr, err))
+		}
+		g.addrs = append(g.addrs, addr)
+		g.sharders[addr] = s
+	}
+	return g, errors.Join(warnings...)
+}
+
+// Sharder returns the Sharder for addr, as given to NewGroup, or nil if the
+// group has no such address.
+func (g *Group) Sharder(addr string) *Sharder {
+	return g.sharders[addr]
+}
+
+// Serve serves every address of the group with its handler in handlers,
+// keyed by the addresses given to NewGroup, and blocks until all of them have
+// stopped. If any address fails, the others are closed and the first error is
+// returned, as Sharder.Serve does for its shards.
+func (g *Group) Serve(handlers map[string]http.Handler) error {
+	for _, addr := range g.addrs {
+		if handlers[addr] == nil {
+			return fmt.Errorf("reuseport: no handler for %s", addr)
+		}
+	}
+
+	errc := make(chan error, len(g.addrs))
+	for _, addr := range g.addrs {
+		go func(addr string) {
+			err := g.sharders[addr].Serve(handlers[addr])
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errc <- fmt.Errorf("reuseport: serve %s: %w", addr, err)
+				return
+			}
+			errc <- nil
+		}(addr)
+	}
+
+	var first error
+	for range g.addrs {
+		err := <-errc
+		if err == nil || first != nil {
+			continue
+		}
+		first = err
+		// An expired context makes Shutdown close the servers right away.
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		g.Shutdown(ctx)
+	}
+	return first
+}
+
+// Handle accepts connections on every shard of every address and hands them
+// all to a single pool of workers, sized by WithWorkers, which call fn for
+// each of them. It works like Sharder.Handle, but bounds the number of
+// connections handled at once across the whole group. fn can tell the
+// addresses apart by the LocalAddr of the connection.
+//
+// Handle blocks until every address has stopped. On Shutdown the queued
+// connections are still handed to the workers, and Handle returns once every
+// worker has returned. Shutting down a single address through its Sharder
+// only stops it from accepting, while the other addresses keep feeding the
+// workers; Group.Shutdown is the one that waits for them. If any address
+// fails, the others are closed and the first error is returned, as Serve
+// does.
+func (g *Group) Handle(fn func(net.Conn)) error {
+	p := newWorkerPool(g.workers, fn)
+	g.mu.Lock()
+	g.handled = p.done
+	g.mu.Unlock()
+
+	errc := make(chan error, len(g.addrs))
+	for _, addr := range g.addrs {
+		go func(addr string) {
+			// The Sharders do not wait for the shared workers, which only
+			// finish once every address has stopped.
+			err := g.sharders[addr].handle(p, nil)
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errc <- fmt.Errorf("reuseport: handle %s: %w", addr, err)
+				return
+			}
+			errc <- nil
+		}(addr)
+	}
+
+	var first error
+	for range g.addrs {
+		err := <-errc
+		if err == nil || first != nil {
+			continue
+		}
+		first = err
+		// The workers only finish once Handle returns, so do not wait for
+		// them here.
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		g.Shutdown(ctx)
+	}
+	p.close()
+	return first
+}
+
+// Shutdown gracefully shuts down every address of the group concurrently, as
+// Sharder.Shutdown does, and returns the combined error. After Handle, it
+// then waits for the workers to finish the queued connections.
+func (g *Group) Shutdown(ctx context.Context) error {
+	errs := make([]error, len(g.addrs))
+	var wg sync.WaitGroup
+	for i, addr := range g.addrs {
+		wg.Add(1)
+		go func(i int, s *Sharder) {
+			defer wg.Done()
+			errs[i] = s.Shutdown(ctx)
+		}(i, g.sharders[addr])
+	}
+	wg.Wait()
+
+	g.mu.Lock()
+	handled := g.handled
+	g.mu.Unlock()
+	if handled != nil {
+		select {
+		case <-handled:
+		case <-ctx.Done():
+			errs = append(errs, fmt.Errorf("reuseport: shutdown workers: %w", ctx.Err()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Stats returns the accept counters of every shard, keyed by the addresses
+// given to NewGroup.
+func (g *Group) Stats() map[string][]ShardStat {
+	stats := make(map[string][]ShardStat, len(g.addrs))
+	for _, addr := range g.addrs {
+		stats[addr] = g.sharders[addr].Stats()
+	}
+	return stats
+}
+
+// ActiveConns returns the number of open connections across all addresses.
+func (g *Group) ActiveConns() int64 {
+	var n int64
+	for _, addr := range g.addrs {
+		n += g.sharders[addr].ActiveConns()
+	}
+	return n
+}
@@ -0,0 +1,115 @@
+package reuseport
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testAddrs are the addresses of the groups opened by newTestGroup.
+var testAddrs = []string{"127.0.0.1:0", "[::1]:0"}
+
+func newTestGroup(t *testing.T, opts ...SharderOption) *Group {
+	t.Helper()
+	g, err := NewGroupWithOptions(2, testAddrs, opts...)
+	if err != nil {
+		t.Skipf("no IPv4 and IPv6 loopback: %v", err)
+	}
+	t.Cleanup(func() { g.Shutdown(context.Background()) })
+	return g
+}
+
+func TestGroupHandle(t *testing.T) {
+	const workers, conns = 2, 8
+	g := newTestGroup(t, WithWorkers(workers))
+
+	var mu sync.Mutex
+	handled := make(map[string]int)
+	var active, peak atomic.Int32
+	errc := make(chan error, 1)
+	go func() {
+		errc <- g.Handle(func(c net.Conn) {
+			defer c.Close()
+			n := active.Add(1)
+			defer active.Add(-1)
+			for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+			}
+			time.Sleep(10 * time.Millisecond)
+
+			host, _, _ := net.SplitHostPort(c.LocalAddr().String())
+			mu.Lock()
+			handled[host]++
+			mu.Unlock()
+		})
+	}()
+
+	var wg sync.WaitGroup
+	for _, addr := range testAddrs {
+		a := g.Sharder(addr).Addr().String()
+		for i := 0; i < conns/2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c, err := net.Dial("tcp", a)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				defer c.Close()
+				// The worker closes the connection once it is handled.
+				c.Read(make([]byte, 1))
+			}()
+		}
+	}
+	wg.Wait()
+
+	if err := g.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("Handle = %v, want nil after Shutdown", err)
+	}
+	if handled["127.0.0.1"] != conns/2 || handled["::1"] != conns/2 {
+		t.Errorf("handled %v, want %d connections per address", handled, conns/2)
+	}
+	if p := peak.Load(); p > workers {
+		t.Errorf("%d connections handled at once, want at most %d", p, workers)
+	}
+}
+
+func TestGroupHandleShutdownOne(t *testing.T) {
+	g := newTestGroup(t, WithWorkers(1))
+	errc := make(chan error, 1)
+	go func() {
+		errc <- g.Handle(func(c net.Conn) { c.Close() })
+	}()
+	v4, v6 := g.Sharder(testAddrs[0]), g.Sharder(testAddrs[1])
+	waitFor(t, "Handle to start", func() bool { return v4.up() && v6.up() })
+
+	// A single address does not wait for the workers shared with the other
+	// one, which keeps being served.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := v4.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown of one address = %v", err)
+	}
+	c, err := net.Dial("tcp", v6.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Read(make([]byte, 1))
+	c.Close()
+	if n := accepted(v6); n != 1 {
+		t.Errorf("accepted %d connections on %s, want 1", n, testAddrs[1])
+	}
+
+	if err := g.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("Handle = %v, want nil after Shutdown", err)
+	}
+}
@@ -0,0 +1,109 @@
+package reuseport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime"
+	"sync"
+)
+
+// WithWorkers sets the number of goroutines Sharder.Handle and Group.Handle
+// run to handle connections. It defaults to runtime.NumCPU().
+func WithWorkers(n int) SharderOption {
+	return sharderOption(func(o *options) {
+		o.workers = n
+	})
+}
+
+// Handle accepts connections on every shard and hands them to a fixed pool of
+// workers, sized by WithWorkers, which call fn for each of them. Unlike
+// AcceptLoop it bounds the number of connections handled at once: while all
+// workers are busy and the queue, one slot per worker, is full, the shards
+// stop accepting and new connections wait in the kernel accept queues.
+//
+// Handle blocks until the shards stop. On Shutdown the listeners are closed,
+// the connections already queued are still handed to the workers, and Handle
+// returns nil once every worker has returned; connections left in the kernel
+// accept queues are reset. If a shard fails, the others are closed and the
+// error is returned after the workers have finished.
+// Handle returns http.ErrServerClosed if it is called after Shutdown.
+func (s *Sharder) Handle(fn func(net.Conn)) error {
+	p := newWorkerPool(s.opts.workers, fn)
+	err := s.handle(p, p.done)
+	p.close()
+	return err
+}
+
+// handle runs an accept loop on every shard that hands the connections to p,
+// and blocks until all of them have returned. Shutdown and Close wait for
+// handled to be closed if it is not nil.
+func (s *Sharder) handle(p *workerPool, handled chan struct{}) error {
+	return s.run(nil, handled, func(i int, l net.Listener) error {
+		if err := acceptLoop(context.Background(), l, p.put); err != nil {
+			return fmt.Errorf("reuseport: accept shard %d: %w", i, err)
+		}
+		return nil
+	}, func() {
+		closeAll(s.shards())
+	})
+}
+
+// workerPool calls fn for the connections put into it from a fixed number of
+// goroutines.
+type workerPool struct {
+	conns chan net.Conn
+	wg    sync.WaitGroup
+	done  chan struct{}
+}
+
+func newWorkerPool(workers int, fn func(net.Conn)) *workerPool {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	p := &workerPool{
+		conns: make(chan net.Conn, workers),
+		done:  make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for c := range p.conns {
+				fn(c)
+			}
+		}()
+	}
+	return p
+}
+
+// put queues c for a worker, blocking while the queue is full.
+func (p *workerPool) put(c net.Conn) {
+	p.conns <- c
+}
+
+// close waits for the workers to handle the queued connections and closes
+// p.done. Nothing may be put into p afterwards.
+func (p *workerPool) close() {
+	close(p.conns)
+	p.wg.Wait()
+	close(p.done)
+}
+
+// AcceptLoop runs AcceptLoop with handle on every shard, each in its own
+// goroutine, and blocks until all of them have returned. It is the
+// counterpart of Serve for raw connections: unlike running AcceptLoop on the
+// Listeners, the loops honour WithLockedThreads, follow Reload and are waited
+// for by Close. If a shard fails, the others are closed and the error is
+// returned. AcceptLoop returns http.ErrServerClosed if it is called after
+// Shutdown.
+func (s *Sharder) AcceptLoop(ctx context.Context, handle func(net.Conn)) error {
+	return s.run(nil, nil, func(i int, l net.Listener) error {
+		if err := AcceptLoop(ctx, l, handle); err != nil {
+			return fmt.Errorf("reuseport: accept shard %d: %w", i, err)
+		}
+		return nil
+	}, func() {
+		closeAll(s.shards())
+	})
+}
@@ -0,0 +1,66 @@
+package reuseport
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// HealthHandler returns a handler for the Kubernetes-style probes of s:
+//
+//   - /healthz answers 200 while s is serving, through Serve, ServeTLS or
+//     Handle, and at least one shard is accepting connections, and 503
+//     otherwise.
+//   - /readyz answers like /healthz, but also switches to 503 for good as
+//     soon as MarkNotReady or DrainShard is called, so that load balancers
+//     stop routing to a process that has begun draining.
+//
+// Shutdown and Close refuse new connections as soon as they are called, so a
+// load balancer polling /readyz would only notice once it is too late. To
+// drain without refusing connections, call MarkNotReady, wait for the load
+// balancers to stop routing to the process, then call Shutdown.
+//
+// Any other path is answered with 404. The draining sockets left behind by
+// Reload do not affect either probe.
+func HealthHandler(s *Sharder) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			writeProbe(w, s.up())
+		case "/readyz":
+			writeProbe(w, !s.notReady.Load() && s.up())
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+// MarkNotReady makes the /readyz probe of HealthHandler answer 503 for good,
+// while the shards keep accepting and serving connections as before.
+func (s *Sharder) MarkNotReady() {
+	s.notReady.Store(true)
+}
+
+func writeProbe(w http.ResponseWriter, ok bool) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if !ok {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, "unavailable")
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// up reports whether s is serving and at least one shard is not drained.
+func (s *Sharder) up() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.serving || s.inShutdown {
+		return false
+	}
+	for _, l := range s.listeners {
+		if !l.draining.Load() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package reuseport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func probe(h http.Handler, path string) int {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+	return w.Code
+}
+
+func TestHealthHandler(t *testing.T) {
+	s := newTestSharder(t, 2)
+	h := HealthHandler(s)
+	check := func(when string, healthz, readyz int) {
+		t.Helper()
+		if got := probe(h, "/healthz"); got != healthz {
+			t.Errorf("%s: /healthz = %d, want %d", when, got, healthz)
+		}
+		if got := probe(h, "/readyz"); got != readyz {
+			t.Errorf("%s: /readyz = %d, want %d", when, got, readyz)
+		}
+	}
+
+	check("before Serve", http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.Serve(http.NotFoundHandler()) }()
+	waitFor(t, "Serve to start", s.up)
+	check("serving", http.StatusOK, http.StatusOK)
+
+	// Reload drains the old sockets without the process draining.
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	check("after Reload", http.StatusOK, http.StatusOK)
+
+	if err := s.DrainShard(0); err != nil {
+		t.Fatal(err)
+	}
+	check("with one shard drained", http.StatusOK, http.StatusServiceUnavailable)
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		t.Fatal(err)
+	}
+	check("after Shutdown", http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+}
+
+func TestHealthHandlerMarkNotReady(t *testing.T) {
+	s := newTestSharder(t, 2)
+	h := HealthHandler(s)
+	go s.Serve(http.NotFoundHandler())
+	waitFor(t, "Serve to start", s.up)
+
+	s.MarkNotReady()
+	if got := probe(h, "/healthz"); got != http.StatusOK {
+		t.Errorf("after MarkNotReady, /healthz = %d, want 200", got)
+	}
+	if got := probe(h, "/readyz"); got != http.StatusServiceUnavailable {
+		t.Errorf("after MarkNotReady, /readyz = %d, want 503", got)
+	}
+}
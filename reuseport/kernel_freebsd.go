@@ -0,0 +1,12 @@
+package reuseport
+
+// SO_REUSEPORT_LB, which setReusePort falls back from on older kernels, is
+// only available since FreeBSD 12.0.
+const minKernelMajor, minKernelMinor = 12, 0
+
+// CheckKernelSupport returns an error if the running kernel accepts
+// SO_REUSEPORT without balancing connections across the sockets sharing a
+// port, that is, on FreeBSD older than 12.0.
+func CheckKernelSupport() error {
+	return checkKernelRelease(minKernelMajor, minKernelMinor)
+}
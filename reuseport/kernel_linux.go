@@ -0,0 +1,12 @@
+package reuseport
+
+// SO_REUSEPORT is accepted by older kernels, but connections are only
+// balanced across the sockets of a group since Linux 3.9.
+const minKernelMajor, minKernelMinor = 3, 9
+
+// CheckKernelSupport returns an error if the running kernel accepts
+// SO_REUSEPORT without balancing connections across the sockets sharing a
+// port, that is, on Linux older than 3.9.
+func CheckKernelSupport() error {
+	return checkKernelRelease(minKernelMajor, minKernelMinor)
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !freebsd
+
+package reuseport
+
+import "errors"
+
+// CheckKernelSupport returns an error if the running kernel accepts
+// SO_REUSEPORT without balancing connections across the sockets sharing a
+// port. Only Linux and FreeBSD balance them, so it always returns an error here.
+func CheckKernelSupport() error {
+	return errors.New("reuseport: SO_REUSEPORT does not balance connections on this platform")
+}
@@ -0,0 +1,30 @@
+//go:build linux || freebsd
+
+package reuseport
+
+import (
+	"bytes"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// checkKernelRelease returns an error if the release of the running kernel
+// is older than major.minor.
+func checkKernelRelease(major, minor int) error {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return fmt.Errorf("reuseport: uname: %w", err)
+	}
+	release := string(bytes.TrimRight(uts.Release[:], "\x00"))
+
+	var gotMajor, gotMinor int
+	if _, err := fmt.Sscanf(release, "%d.%d", &gotMajor, &gotMinor); err != nil {
+		return fmt.Errorf("reuseport: parse kernel release %q: %w", release, err)
+	}
+	if gotMajor < major || gotMajor == major && gotMinor < minor {
+		return fmt.Errorf("reuseport: kernel %s does not balance SO_REUSEPORT connections, %d.%d or later is required",
+			release, major, minor)
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build linux || freebsd
+
+package reuseport
+
+import "testing"
+
+func TestCheckKernelRelease(t *testing.T) {
+	if err := CheckKernelSupport(); err != nil {
+		t.Fatalf("CheckKernelSupport = %v", err)
+	}
+	if err := checkKernelRelease(1, 0); err != nil {
+		t.Errorf("checkKernelRelease(1, 0) = %v, want nil", err)
+	}
+	if err := checkKernelRelease(1000, 0); err == nil {
+		t.Error("checkKernelRelease(1000, 0) = nil, want an error")
+	}
+}
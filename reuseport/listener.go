@@ -0,0 +1,309 @@
+package reuseport
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// ErrDraining is returned by Accept on a shard listener that has been drained.
+var ErrDraining = errors.New("reuseport: listener is draining")
+
+// shardCounters are the counters of a shard. They belong to the shard rather
+// than to its listener so that they carry over when the listener is replaced.
+//
+// slots, when WithMaxConns is set, holds one element per open connection.
+type shardCounters struct {
+	accepted atomic.Uint64
+	active   atomic.Int64
+	slots    chan struct{}
+}
+
+// instrumentedListener counts the connections successfully accepted from the
+// wrapped listener, and tracks how many of them are still open. The wrapped
+// listener is available as il.Listener.
+type instrumentedListener struct {
+	*connTrackingListener
+	counters *shardCounters
+	draining atomic.Bool
+}
+
+func newInstrumentedListener(l net.Listener, c *shardCounters, linger time.Duration) *instrumentedListener {
+	return &instrumentedListener{
+		connTrackingListener: &connTrackingListener{
+			Listener: l,
+			active:   &c.active,
+			slots:    c.slots,
+			linger:   linger,
+			done:     make(chan struct{}),
+			wake:     make(chan struct{}),
+		},
+		counters: c,
+	}
+}
+
+func (il *instrumentedListener) Accept() (net.Conn, error) {
+	c, err := il.connTrackingListener.Accept()
+	if err != nil {
+		if il.draining.Load() {
+			return nil, ErrDraining
+		}
+		return nil, err
+	}
+	il.counters.accepted.Add(1)
+	return c, nil
+}
+
+// Drain stops the listener from accepting new connections while leaving the
+// connections it already accepted untouched; subsequent Accept calls return
+// ErrDraining.
+//
+// Keeping the socket open would let the kernel keep queueing connections that
+// nobody accepts, so Drain closes it instead: the socket leaves the reuseport
+// group and new connections go to the remaining shards. The tradeoff is that
+// connections still waiting in this socket's accept queue are reset, unless
+// net.ipv4.tcp_migrate_req is enabled (Linux 5.14+) to move them to another
+// socket of the group.
+func (il *instrumentedListener) Drain() error {
+	if !il.draining.CompareAndSwap(false, true) {
+		return nil
+	}
+	il.stop()
+	return il.Listener.Close()
+}
+
+// Close closes the listener. It is a no-op once the listener is drained.
+func (il *instrumentedListener) Close() error {
+	if il.draining.Load() {
+		return nil
+	}
+	il.stop()
+	return il.Listener.Close()
+}
+
+// SetDeadline sets the deadline of the wrapped listener, so that shard
+// listeners can be used with AcceptContext without being closed.
+func (il *instrumentedListener) SetDeadline(t time.Time) error {
+	dl, ok := il.Listener.(deadlineListener)
+	if !ok {
+		return fmt.Errorf("reuseport: %T does not support deadlines", il.Listener)
+	}
+	if err := dl.SetDeadline(t); err != nil {
+		return err
+	}
+	il.setDeadline(t)
+	return nil
+}
+
+// SyscallConn returns the raw connection of the wrapped listener, so that
+// helpers such as AttachReusePortEBPF work on shard listeners.
+func (il *instrumentedListener) SyscallConn() (syscall.RawConn, error) {
+	sc, ok := il.Listener.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("reuseport: %T has no file descriptor", il.Listener)
+	}
+	return sc.SyscallConn()
+}
+
+// connTrackingListener keeps a gauge of the accepted connections that have
+// not been closed yet, and sets SO_LINGER on them unless linger is negative.
+// If slots is not nil, Accept first waits for a free slot in it.
+type connTrackingListener struct {
+	net.Listener
+	active *atomic.Int64
+	slots  chan struct{}
+	linger time.Duration
+
+	// done is closed by stop. wake is closed and replaced whenever the
+	// deadline changes, to wake up an Accept waiting for a slot.
+	done     chan struct{}
+	stopOnce sync.Once
+	mu       sync.Mutex
+	deadline time.Time
+	wake     chan struct{}
+}
+
+func (cl *connTrackingListener) Accept() (net.Conn, error) {
+	if cl.slots != nil {
+		if err := cl.acquire(); err != nil {
+			return nil, err
+		}
+	}
+	c, err := cl.Listener.Accept()
+	if err != nil {
+		cl.release()
+		return nil, err
+	}
+	if cl.linger >= 0 {
+		setLinger(c, cl.linger)
+	}
+	cl.active.Add(1)
+	return &trackedConn{Conn: c, active: cl.active, release: cl.release}, nil
+}
+
+// acquire waits for a free slot, until the listener is stopped or its
+// deadline passes. The errors are the ones Accept would return in those
+// cases.
+func (cl *connTrackingListener) acquire() error {
+	for {
+		cl.mu.Lock()
+		deadline, wake := cl.deadline, cl.wake
+		cl.mu.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return cl.acceptError(os.ErrDeadlineExceeded)
+			}
+			timer = time.NewTimer(d)
+			timeout = timer.C
+		}
+
+		var err error
+		select {
+		case cl.slots <- struct{}{}:
+		case <-cl.done:
+			err = cl.acceptError(net.ErrClosed)
+		case <-timeout:
+			err = cl.acceptError(os.ErrDeadlineExceeded)
+		case <-wake:
+			err = errDeadlineChanged
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != errDeadlineChanged {
+			return err
+		}
+	}
+}
+
+// errDeadlineChanged makes acquire wait again with the new deadline.
+var errDeadlineChanged = errors.New("deadline changed")
+
+func (cl *connTrackingListener) acceptError(err error) error {
+	addr := cl.Addr()
+	return &net.OpError{Op: "accept", Net: addr.Network(), Addr: addr, Err: err}
+}
+
+// release frees the slot taken by acquire.
+func (cl *connTrackingListener) release() {
+	if cl.slots != nil {
+		<-cl.slots
+	}
+}
+
+// setDeadline records the deadline of the wrapped listener for acquire.
+func (cl *connTrackingListener) setDeadline(t time.Time) {
+	cl.mu.Lock()
+	cl.deadline = t
+	close(cl.wake)
+	cl.wake = make(chan struct{})
+	cl.mu.Unlock()
+}
+
+// stop makes every Accept waiting for a slot return. It must be called when
+// the wrapped listener is closed.
+func (cl *connTrackingListener) stop() {
+	cl.stopOnce.Do(func() {
+		close(cl.done)
+	})
+}
+
+// trackedConn decrements the gauge of its listener, and releases its slot,
+// when it is closed.
+type trackedConn struct {
+	net.Conn
+	active  *atomic.Int64
+	release func()
+	closed  atomic.Bool
+}
+
+// Close closes the connection. The gauge is only decremented by the first
+// call, however many times Close is called.
+func (c *trackedConn) Close() error {
+	err := c.Conn.Close()
+	if c.closed.CompareAndSwap(false, true) {
+		c.active.Add(-1)
+		c.release()
+	}
+	return err
+}
+
+// NetConn returns the wrapped connection, a *net.TCPConn for TCP shards, for
+// access to methods such as CloseWrite and SetKeepAlive. The gauge is not
+// decremented if that connection is closed directly.
+func (c *trackedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// ReadFrom uses the ReadFrom method of the wrapped connection if it has one,
+// so that net/http keeps sending files with sendfile.
+func (c *trackedConn) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := c.Conn.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(struct{ io.Writer }{c.Conn}, r)
+}
+
+// SyscallConn returns the raw connection of the wrapped connection, so that
+// helpers such as NapiID work on connections accepted by a Sharder.
+func (c *trackedConn) SyscallConn() (syscall.RawConn, error) {
+	sc, ok := c.Conn.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("reuseport: %T has no file descriptor", c.Conn)
+	}
+	return sc.SyscallConn()
+}
+
+// lingerListener sets SO_LINGER on the connections it accepts.
+type lingerListener struct {
+	net.Listener
+	linger time.Duration
+}
+
+func (ll *lingerListener) Accept() (net.Conn, error) {
+	c, err := ll.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	setLinger(c, ll.linger)
+	return c, nil
+}
+
+// SetDeadline sets the deadline of the wrapped listener, so that the
+// listener can be used with AcceptContext without being closed.
+func (ll *lingerListener) SetDeadline(t time.Time) error {
+	dl, ok := ll.Listener.(deadlineListener)
+	if !ok {
+		return fmt.Errorf("reuseport: %T does not support deadlines", ll.Listener)
+	}
+	return dl.SetDeadline(t)
+}
+
+// SyscallConn returns the raw connection of the wrapped listener, so that
+// helpers such as AttachReusePortCBPF work on the listener.
+func (ll *lingerListener) SyscallConn() (syscall.RawConn, error) {
+	sc, ok := ll.Listener.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("reuseport: %T has no file descriptor", ll.Listener)
+	}
+	return sc.SyscallConn()
+}
+
+// setLinger sets SO_LINGER to d, rounded up to a whole second, on c if it is
+// a TCP connection. Like the keep-alive settings Go applies to accepted
+// connections, a failure is ignored rather than failing the Accept.
+func setLinger(c net.Conn, d time.Duration) {
+	if tc, ok := c.(*net.TCPConn); ok {
+		tc.SetLinger(int((d + time.Second - 1) / time.Second))
+	}
+}
@@ -0,0 +1,62 @@
+package reuseport
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrackedConn(t *testing.T) {
+	s := newTestSharder(t, 2)
+	c, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var ac net.Conn
+	for i, l := range s.shards() {
+		l.SetDeadline(time.Now().Add(50 * time.Millisecond))
+		if ac, err = l.Accept(); err == nil {
+			defer ac.Close()
+			if got := s.ListenerFor(ac); got != i {
+				t.Errorf("ListenerFor = %d, want %d", got, i)
+			}
+			if got := s.ListenerFor(tls.Server(ac, &tls.Config{})); got != i {
+				t.Errorf("ListenerFor on a TLS connection = %d, want %d", got, i)
+			}
+			break
+		}
+	}
+	if ac == nil {
+		t.Fatal("no shard accepted the connection")
+	}
+
+	nc, ok := ac.(interface{ NetConn() net.Conn })
+	if !ok {
+		t.Fatalf("%T has no NetConn method", ac)
+	}
+	if _, ok := nc.NetConn().(*net.TCPConn); !ok {
+		t.Errorf("NetConn returned %T, want *net.TCPConn", nc.NetConn())
+	}
+
+	// net/http sends files through io.ReaderFrom.
+	rf, ok := ac.(io.ReaderFrom)
+	if !ok {
+		t.Fatalf("%T does not implement io.ReaderFrom", ac)
+	}
+	if _, err := rf.ReadFrom(strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	ac.Close()
+	b, err := io.ReadAll(c)
+	if err != nil || string(b) != "hello" {
+		t.Fatalf("read %q, %v, want hello", b, err)
+	}
+	if n := s.ActiveConns(); n != 0 {
+		t.Errorf("ActiveConns = %d after Close, want 0", n)
+	}
+}
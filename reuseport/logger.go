@@ -0,0 +1,23 @@
+package reuseport
+
+// Logger receives the lifecycle events of a Sharder: shards opening,
+// serving, stopping, draining and closing. *log.Logger satisfies it, and so
+// does a Printf method forwarding to log/slog.
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+// WithLogger makes a Sharder log the lifecycle events of its shards to l,
+// each with the shard index and address. By default nothing is logged.
+func WithLogger(l Logger) SharderOption {
+	return sharderOption(func(o *options) {
+		o.logger = l
+	})
+}
+
+// logf logs to the logger selected by WithLogger, if any.
+func (s *Sharder) logf(format string, v ...any) {
+	if s.opts.logger != nil {
+		s.opts.logger.Printf(format, v...)
+	}
+}
@@ -0,0 +1,43 @@
+package reuseport
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+)
+
+// WriteMetrics writes the counters of every shard to w in the Prometheus text
+// exposition format, so they can be served from a /metrics handler without
+// depending on a Prometheus client:
+//
+//	# HELP shard_accepted_total Connections accepted by the shard.
+//	# TYPE shard_accepted_total counter
+//	shard_accepted_total{shard="0"} 123
+//	# HELP shard_active_connections Connections accepted by the shard and not closed yet.
+//	# TYPE shard_active_connections gauge
+//	shard_active_connections{shard="0"} 4
+//
+// The values are the ones reported by Stats and ActiveConns.
+func (s *Sharder) WriteMetrics(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("# HELP shard_accepted_total Connections accepted by the shard.\n")
+	bw.WriteString("# TYPE shard_accepted_total counter\n")
+	for _, st := range s.Stats() {
+		writeMetric(bw, "shard_accepted_total", st.Index, strconv.FormatUint(st.Accepted, 10))
+	}
+	bw.WriteString("# HELP shard_active_connections Connections accepted by the shard and not closed yet.\n")
+	bw.WriteString("# TYPE shard_active_connections gauge\n")
+	for i, c := range s.counters {
+		writeMetric(bw, "shard_active_connections", i, strconv.FormatInt(c.active.Load(), 10))
+	}
+	return bw.Flush()
+}
+
+func writeMetric(w *bufio.Writer, name string, shard int, value string) {
+	w.WriteString(name)
+	w.WriteString(`{shard="`)
+	w.WriteString(strconv.Itoa(shard))
+	w.WriteString(`"} `)
+	w.WriteString(value)
+	w.WriteByte('\n')
+}
@@ -0,0 +1,38 @@
+package reuseport
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrNoNapiID is returned by NapiID for connections whose packets were not
+// received through a NAPI context, such as loopback connections or those on
+// drivers without busy polling support.
+var ErrNoNapiID = errors.New("reuseport: connection has no NAPI ID")
+
+// NapiID returns the SO_INCOMING_NAPI_ID of conn, which identifies the NIC
+// receive queue that delivered its packets. It can be used to hand the
+// connection to a worker running on the core that services that queue.
+//
+// It returns an error on kernels older than 4.12, which do not support the
+// option, and ErrNoNapiID rather than zero when no NAPI ID has been recorded.
+func NapiID(conn net.Conn) (int, error) {
+	var id int
+	if err := controlConn(conn, func(fd uintptr) error {
+		var err error
+		id, err = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_INCOMING_NAPI_ID)
+		return err
+	}); err != nil {
+		if errors.Is(err, unix.ENOPROTOOPT) {
+			return 0, fmt.Errorf("reuseport: SO_INCOMING_NAPI_ID not supported by this kernel: %w", err)
+		}
+		return 0, err
+	}
+	if id == 0 {
+		return 0, ErrNoNapiID
+	}
+	return id, nil
+}
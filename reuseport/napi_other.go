@@ -0,0 +1,19 @@
+//go:build !linux
+
+package reuseport
+
+import (
+	"errors"
+	"net"
+)
+
+// ErrNoNapiID is returned by NapiID for connections whose packets were not
+// received through a NAPI context, such as loopback connections or those on
+// drivers without busy polling support.
+var ErrNoNapiID = errors.New("reuseport: connection has no NAPI ID")
+
+// NapiID returns the SO_INCOMING_NAPI_ID of conn. It is only supported on
+// Linux.
+func NapiID(conn net.Conn) (int, error) {
+	return 0, linuxOnly("SO_INCOMING_NAPI_ID")
+}
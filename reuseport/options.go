@@ -0,0 +1,319 @@
+package reuseport
+
+import (
+	"net"
+	"time"
+)
+
+// Option sets a socket option in the Control function of the sockets
+// created by NewListenConfig, WrapListenConfig, ListenPacket, Listen and
+// NewSharder.
+type Option func(*options)
+
+// ListenOption configures the listeners created by Listen, ListenWithRetry
+// and NewSharder. Every Option is a ListenOption; the ones that are not an
+// Option, such as WithBacklog, act on the listener once it is open and
+// cannot be applied by a bare Control function.
+type ListenOption interface {
+	SharderOption
+	applyListen(o *options)
+}
+
+// SharderOption configures a Sharder. Every ListenOption is a SharderOption;
+// the ones that are not, such as WithWorkers, only make sense for a Sharder.
+type SharderOption interface {
+	applySharder(o *options)
+}
+
+func (f Option) applyListen(o *options)  { f(o) }
+func (f Option) applySharder(o *options) { f(o) }
+
+type listenOption func(*options)
+
+func (f listenOption) applyListen(o *options)  { f(o) }
+func (f listenOption) applySharder(o *options) { f(o) }
+
+type sharderOption func(*options)
+
+func (f sharderOption) applySharder(o *options) { f(o) }
+
+type options struct {
+	reusePort    bool
+	reuseAddr    bool
+	reuseAddrSet bool
+	backlog      int
+
+	incomingCPU    int
+	incomingCPUSet bool
+	cpuAffinity    bool
+	lockedThreads  bool
+
+	attachBPF func(net.Listener) error
+
+	readBuffer  int
+	writeBuffer int
+
+	deferAccept int
+	device      string
+	congestion  string
+
+	ipv6Only    bool
+	ipv6OnlySet bool
+
+	logger    Logger
+	workers   int
+	minShards int
+
+	raiseFDLimit bool
+
+	networkValidator func(network string) error
+
+	linger   time.Duration
+	maxConns int
+}
+
+func defaultOptions() *options {
+	return &options{reusePort: true, linger: -1}
+}
+
+func newOptions(opts []Option) *options {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+func newListenOptions(opts []ListenOption) *options {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt.applyListen(o)
+	}
+	return o
+}
+
+func newSharderOptions(opts []SharderOption) *options {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt.applySharder(o)
+	}
+	return o
+}
+
+// WithReusePort controls whether SO_REUSEPORT is set, which it is by
+// default. With WithReusePort(false) a second listener on the same address
+// fails with "address already in use", which is handy to catch a duplicate
+// instance during local development while keeping the same code path as in
+// production. The other options are still applied.
+func WithReusePort(enabled bool) Option {
+	return func(o *options) {
+		o.reusePort = enabled
+	}
+}
+
+// WithReuseAddr sets SO_REUSEADDR to enabled alongside SO_REUSEPORT.
+//
+// On Linux SO_REUSEPORT already lets the sockets of a group share the port,
+// but SO_REUSEADDR is still useful to rebind quickly while connections from a
+// previous process linger in TIME_WAIT. Go sets SO_REUSEADDR on every TCP
+// listener by default, so the option mostly matters for UDP sockets, or to
+// turn it off for TCP with WithReuseAddr(false).
+func WithReuseAddr(enabled bool) Option {
+	return func(o *options) {
+		o.reuseAddr = enabled
+		o.reuseAddrSet = true
+	}
+}
+
+// WithBacklog sets the length of the accept queue of stream listeners to n.
+//
+// Go always listens with the backlog read from net.core.somaxconn, and the
+// Control function runs before the socket is bound, so the backlog is applied
+// by calling listen(2) again once the listener is open, which is why it is a
+// ListenOption and not an Option. The kernel silently caps n at
+// net.core.somaxconn, and the queue of half-open connections is sized
+// separately by tcp_max_syn_backlog.
+func WithBacklog(n int) ListenOption {
+	return listenOption(func(o *options) {
+		o.backlog = n
+	})
+}
+
+// WithIncomingCPU sets SO_INCOMING_CPU to cpu. Within a reuseport group the
+// kernel then prefers this socket for connections whose packets were
+// processed on that CPU. It is only supported on Linux.
+func WithIncomingCPU(cpu int) Option {
+	return func(o *options) {
+		o.incomingCPU = cpu
+		o.incomingCPUSet = true
+	}
+}
+
+// WithCPUAffinity makes NewSharder set SO_INCOMING_CPU on every shard,
+// assigning shard i to the i-th CPU the process is allowed to run on, such as
+// CPU 4+i when it is confined to CPUs 4-7, and wrapping around when there are
+// more shards than CPUs. It overrides WithIncomingCPU.
+func WithCPUAffinity() SharderOption {
+	return sharderOption(func(o *options) {
+		o.cpuAffinity = true
+	})
+}
+
+// WithLockedThreads makes the goroutine that runs the accept loop of every
+// shard, in Sharder.Serve, ServeTLS, Handle and AcceptLoop, lock itself to an
+// OS thread with runtime.LockOSThread and pin that thread to the CPU of the
+// shard with sched_setaffinity. Combined with WithCPUAffinity, every shard
+// then accepts on the CPU whose packets SO_INCOMING_CPU steers to it, so
+// the accept path stays on the core the connection arrived on. Without
+// WithCPUAffinity the CPUs are assigned the same way, or the CPU given to
+// WithIncomingCPU is used for every shard.
+//
+// Only the accept loop is pinned: the goroutines serving the connections are
+// scheduled as usual. Each shard takes a thread out of the pool the Go
+// scheduler runs other goroutines on, though, and a pinned thread cannot be
+// moved off a core that is busy with something else, so this helps accept
+// latency on hosts dedicated to the server with one shard per CPU, and tends
+// to lower throughput otherwise. The locked threads exit along with their
+// accept loops. It is only supported on Linux.
+func WithLockedThreads() SharderOption {
+	return sharderOption(func(o *options) {
+		o.lockedThreads = true
+	})
+}
+
+// WithReadBuffer sets SO_RCVBUF to bytes. On a listener it is set before the
+// socket listens, so that accepted connections inherit it and the TCP window
+// scale is negotiated for it. Linux doubles the value to account for
+// bookkeeping overhead and caps it at net.core.rmem_max.
+func WithReadBuffer(bytes int) Option {
+	return func(o *options) {
+		o.readBuffer = bytes
+	}
+}
+
+// WithWriteBuffer sets SO_SNDBUF to bytes. Like WithReadBuffer, Linux doubles
+// the value, and caps it at net.core.wmem_max.
+func WithWriteBuffer(bytes int) Option {
+	return func(o *options) {
+		o.writeBuffer = bytes
+	}
+}
+
+// WithDeferAccept sets TCP_DEFER_ACCEPT to seconds on TCP listeners, so that
+// Accept only returns a connection once the client has sent data, or is
+// woken up to drop it after the timeout. Connections that never send
+// anything, such as port scans and probes that connect and hang up, then
+// never reach the accept loop. It is only supported on Linux and ignored for
+// other networks.
+func WithDeferAccept(seconds int) Option {
+	return func(o *options) {
+		o.deferAccept = seconds
+	}
+}
+
+// WithCongestionControl sets TCP_CONGESTION to algo, such as "bbr" or
+// "cubic", on TCP listeners, and so on the connections they accept. If the
+// kernel does not provide algo the listener fails to open rather than falling
+// back to the default algorithm; sysctl net.ipv4.tcp_available_congestion_control
+// lists the loaded ones, and unprivileged processes are further restricted to
+// net.ipv4.tcp_allowed_congestion_control. It is only supported on Linux and
+// ignored for other networks.
+func WithCongestionControl(algo string) Option {
+	return func(o *options) {
+		o.congestion = algo
+	}
+}
+
+// WithBindToDevice sets SO_BINDTODEVICE to iface, so that the socket only
+// receives packets arriving on that network interface, for example to keep
+// the traffic of a multi-homed host isolated per interface. It can be
+// combined with a specific address:
+//
+//	reuseport.Listen(ctx, "tcp", "10.0.0.5:8080", reuseport.WithBindToDevice("eth1"))
+//
+// It is only supported on Linux, where it requires CAP_NET_RAW before Linux
+// 5.7. It is ignored for Unix domain sockets.
+func WithBindToDevice(iface string) Option {
+	return func(o *options) {
+		o.device = iface
+	}
+}
+
+// WithIPv6Only sets IPV6_V6ONLY to enabled on tcp6 and udp6 sockets. With
+// WithIPv6Only(false) a socket bound to the IPv6 wildcard address also
+// accepts IPv4 connections, as IPv4-mapped addresses.
+//
+// Go sets IPV6_V6ONLY on every tcp6 and udp6 socket, and clears it on tcp and
+// udp sockets bound to a wildcard address such as ":8080", which are IPv6
+// sockets accepting both families. The option overrides either, so
+// ListenPacket with "udp6" and WithIPv6Only(false) gives a dual-stack socket,
+// and NewSharder with ":8080" and WithIPv6Only(true) an IPv6-only group. It
+// has no effect on sockets bound to an IPv4 address.
+func WithIPv6Only(enabled bool) Option {
+	return func(o *options) {
+		o.ipv6Only = enabled
+		o.ipv6OnlySet = true
+	}
+}
+
+// WithMinShards lets NewSharder succeed with fewer shards than requested, as
+// long as at least m of them open, for example when the process is close to
+// its file descriptor limit. NewSharder then returns the Sharder along with an
+// error matching ErrPartialShards, which should be treated as a warning;
+// Sharder.Len reports how many shards are open. Without the option, NewSharder
+// fails unless every shard opens.
+func WithMinShards(m int) SharderOption {
+	return sharderOption(func(o *options) {
+		o.minShards = m
+	})
+}
+
+// WithRaiseFDLimit makes NewSharder raise the soft RLIMIT_NOFILE to the hard
+// limit before checking that the shards fit. Since Go 1.19 the runtime
+// already does so at startup on most Unix systems, so this only matters when
+// the limit was lowered afterwards, for example by a library or through
+// syscall.Setrlimit. It has no effect on Windows.
+func WithRaiseFDLimit() SharderOption {
+	return sharderOption(func(o *options) {
+		o.raiseFDLimit = true
+	})
+}
+
+// WithNetworkValidator lets SO_REUSEPORT be set for networks other than TCP
+// and UDP, such as the raw IP sockets Go opens for "ip4:sctp". validate is
+// called in the Control function with the network of every such socket, as
+// reported by Go: "ip4" rather than "ip4:sctp". If it returns nil the option
+// is set, otherwise the socket is not opened and its error is returned.
+// Without a validator those sockets are opened without SO_REUSEPORT, while
+// the other options still apply. Unix domain sockets never get it.
+func WithNetworkValidator(validate func(network string) error) Option {
+	return func(o *options) {
+		o.networkValidator = validate
+	}
+}
+
+// WithLinger sets SO_LINGER to d on every connection accepted by Listen,
+// ListenWithRetry or the shards of a Sharder, which bounds how long closing a
+// connection with unsent data may take. With a zero duration, closing a
+// connection discards its unsent data and resets it, so connections stuck on
+// a slow client cannot hold up a forced shutdown; otherwise the data is
+// flushed for up to d, rounded up to a whole second. A negative duration, the
+// default, keeps the system behaviour. With it, Listen and ListenWithRetry
+// return a wrapping listener rather than a *net.TCPListener.
+func WithLinger(d time.Duration) ListenOption {
+	return listenOption(func(o *options) {
+		o.linger = d
+	})
+}
+
+// WithMaxConns makes every shard of a Sharder stop accepting while n of the
+// connections it accepted are still open, and resume as they are closed.
+// Meanwhile new connections wait in the kernel accept queue of the shard, up
+// to its backlog, while the other shards keep accepting. Deadlines set on the
+// shard listeners, and so AcceptContext and AcceptLoop, still interrupt an
+// Accept waiting for a free slot. The limit is kept across Reload.
+func WithMaxConns(n int) SharderOption {
+	return sharderOption(func(o *options) {
+		o.maxConns = n
+	})
+}
@@ -0,0 +1,39 @@
+package reuseport
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// ListenWithRetry is like Listen, but retries up to attempts times in total
+// while the bind fails with EADDRINUSE or EADDRNOTAVAIL, which can happen for
+// a moment during a rapid restart. It waits backoff before the first retry
+// and doubles the wait after every failed attempt. Any other error, or ctx
+// being done, is returned immediately; once the attempts are exhausted the
+// last error is returned.
+func ListenWithRetry(ctx context.Context, network, address string, attempts int, backoff time.Duration, opts ...ListenOption) (net.Listener, error) {
+	o := newListenOptions(opts)
+	for attempt := 1; ; attempt++ {
+		l, err := o.listen(ctx, network, address)
+		if err == nil {
+			return o.wrapListener(l), nil
+		}
+		if attempt >= attempts || !isTransientBindError(err) {
+			return nil, err
+		}
+
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+func isTransientBindError(err error) bool {
+	return isAddrInUse(err) || isAddrNotAvail(err)
+}
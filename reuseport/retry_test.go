@@ -0,0 +1,52 @@
+//go:build unix
+
+package reuseport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestListenWithRetryTransient(t *testing.T) {
+	// A socket without SO_REUSEPORT keeps the port unshareable until closed.
+	blocker, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := blocker.Addr().String()
+	time.AfterFunc(100*time.Millisecond, func() { blocker.Close() })
+
+	l, err := ListenWithRetry(context.Background(), "tcp", addr, 10, 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("ListenWithRetry = %v, want success once the port is freed", err)
+	}
+	l.Close()
+}
+
+func TestListenWithRetryExhausted(t *testing.T) {
+	blocker, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer blocker.Close()
+
+	_, err = ListenWithRetry(context.Background(), "tcp", blocker.Addr().String(), 3, time.Millisecond)
+	if !errors.Is(err, syscall.EADDRINUSE) {
+		t.Fatalf("ListenWithRetry = %v, want EADDRINUSE", err)
+	}
+}
+
+func TestListenWithRetryPermanent(t *testing.T) {
+	start := time.Now()
+	_, err := ListenWithRetry(context.Background(), "tcp", "127.0.0.1:bogus", 5, time.Second)
+	if err == nil {
+		t.Fatal("ListenWithRetry succeeded on an invalid address")
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("ListenWithRetry retried a permanent error for %v", d)
+	}
+}
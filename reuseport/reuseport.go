@@ -0,0 +1,327 @@
+// Package reuseport creates listeners whose sockets have the SO_REUSEPORT
+// option set, so several of them can bind the same address and port and let
+// the kernel distribute incoming connections across them.
+//
+// The socket option is set with the level and option pair of the running
+// platform (Linux, Darwin and the BSDs), using SO_REUSEPORT_LB on FreeBSD,
+// where plain SO_REUSEPORT does not balance. On any other platform the listen
+// functions return an error instead of a plain, unshared socket.
+package reuseport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// ErrPortNotShareable is matched by the errors of Listen, ListenPacket and
+// NewSharder when the address is already in use by a socket that did not set
+// SO_REUSEPORT, or that belongs to another user, so the port cannot be shared.
+var ErrPortNotShareable = errors.New("reuseport: port is not shareable")
+
+// NewListenConfig returns a net.ListenConfig whose Control function sets
+// SO_REUSEPORT, and the socket options selected by opts, on every socket
+// before it is bound.
+func NewListenConfig(opts ...Option) net.ListenConfig {
+	return net.ListenConfig{Control: newOptions(opts).control}
+}
+
+// WrapListenConfig returns a copy of base whose Control function runs the
+// Control function of base, if any, and sets SO_REUSEPORT and the options
+// selected by opts on the same socket, within the same c.Control call: the
+// RawConn passed to base sets them right after the first callback base runs
+// on it returns. If base never calls c.Control, they are set afterwards. If
+// the Control function of base fails, its error is returned.
+func WrapListenConfig(base net.ListenConfig, opts ...Option) net.ListenConfig {
+	o := newOptions(opts)
+	lc := base
+	lc.Control = func(network, address string, c syscall.RawConn) error {
+		if base.Control == nil {
+			return o.control(network, address, c)
+		}
+		rc := &chainedRawConn{RawConn: c, after: func(fd uintptr) error {
+			return o.setsockopts(network, fd)
+		}}
+		if err := base.Control(network, address, rc); err != nil {
+			return err
+		}
+		if !rc.called {
+			return o.control(network, address, c)
+		}
+		return rc.err
+	}
+	return lc
+}
+
+// chainedRawConn runs after on the file descriptor at the end of the first
+// Control callback, so that it shares the c.Control call of the callback.
+type chainedRawConn struct {
+	syscall.RawConn
+	after  func(fd uintptr) error
+	called bool
+	err    error
+}
+
+func (c *chainedRawConn) Control(f func(fd uintptr)) error {
+	return c.RawConn.Control(func(fd uintptr) {
+		f(fd)
+		if !c.called {
+			c.called = true
+			c.err = c.after(fd)
+		}
+	})
+}
+
+// Listen announces on the local network address like net.Listen, using a
+// socket with SO_REUSEPORT set. Errors from the underlying listen stay
+// wrapped, so errors.Is works against syscall errors; EADDRINUSE is also
+// reported as ErrPortNotShareable.
+func Listen(ctx context.Context, network, address string, opts ...ListenOption) (net.Listener, error) {
+	o := newListenOptions(opts)
+	l, err := o.listen(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return o.wrapListener(l), nil
+}
+
+// ListenPacket announces on the local network address like net.ListenPacket,
+// using a socket with SO_REUSEPORT set. For "udp", "udp4" and "udp6" the
+// kernel spreads incoming datagrams across all sockets bound to the address.
+func ListenPacket(ctx context.Context, network, address string, opts ...Option) (net.PacketConn, error) {
+	o := newOptions(opts)
+	lc := net.ListenConfig{Control: o.control}
+	c, err := lc.ListenPacket(ctx, network, address)
+	if err != nil {
+		return nil, o.listenError(network, err)
+	}
+	return c, nil
+}
+
+// ListenFromFile returns a listener for the listening socket f, typically
+// inherited from a parent process through exec.Cmd.ExtraFiles, and sets
+// SO_REUSEPORT on it again so it keeps sharing the port with the sockets of
+// the new process. The socket is already listening, so the listener can be
+// served right away. f is duplicated; closing it is the caller's
+// responsibility.
+func ListenFromFile(f *os.File) (net.Listener, error) {
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, err
+	}
+	if isUnixNetwork(l.Addr().Network()) {
+		return l, nil
+	}
+	if err := controlListener(l, setReusePort); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (o *options) listen(ctx context.Context, network, address string) (net.Listener, error) {
+	lc := net.ListenConfig{Control: o.control}
+	l, err := lc.Listen(ctx, network, address)
+	if err != nil {
+		return nil, o.listenError(network, err)
+	}
+	if o.backlog > 0 {
+		if err := setBacklog(l, o.backlog); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	if o.attachBPF != nil {
+		if err := o.attachBPF(l); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// wrapListener wraps l, as returned by listen, to apply the options that act
+// on accepted connections, such as WithLinger. The shards of a Sharder apply
+// them in their own listener instead.
+func (o *options) wrapListener(l net.Listener) net.Listener {
+	if o.linger >= 0 {
+		return &lingerListener{Listener: l, linger: o.linger}
+	}
+	return l
+}
+
+// listenError wraps err in ErrPortNotShareable if a socket with SO_REUSEPORT
+// could not bind because the address is in use, keeping err wrapped too.
+func (o *options) listenError(network string, err error) error {
+	if !o.reusePort || isUnixNetwork(network) || !isAddrInUse(err) {
+		return err
+	}
+	return fmt.Errorf("%w: another socket is bound without SO_REUSEPORT or by another user: %w", ErrPortNotShareable, err)
+}
+
+func (o *options) control(network, address string, c syscall.RawConn) error {
+	var opErr error
+	if err := c.Control(func(fd uintptr) {
+		opErr = o.setsockopts(network, fd)
+	}); err != nil {
+		return err
+	}
+	return opErr
+}
+
+// setsockopts applies every selected option to fd, a socket for network. It
+// runs inside a single c.Control callback.
+func (o *options) setsockopts(network string, fd uintptr) error {
+	if o.reuseAddrSet {
+		if err := setReuseAddr(fd, o.reuseAddr); err != nil {
+			return err
+		}
+	}
+	if o.readBuffer > 0 {
+		if err := setReadBuffer(fd, o.readBuffer); err != nil {
+			return err
+		}
+	}
+	if o.writeBuffer > 0 {
+		if err := setWriteBuffer(fd, o.writeBuffer); err != nil {
+			return err
+		}
+	}
+	if o.incomingCPUSet {
+		if err := setIncomingCPU(fd, o.incomingCPU); err != nil {
+			return err
+		}
+	}
+	if o.ipv6OnlySet && (network == "tcp6" || network == "udp6") {
+		if err := setIPv6Only(fd, o.ipv6Only); err != nil {
+			return err
+		}
+	}
+	if o.device != "" && !isUnixNetwork(network) {
+		if err := bindToDevice(fd, o.device); err != nil {
+			return err
+		}
+	}
+	if o.congestion != "" && isTCPNetwork(network) {
+		if err := setCongestion(fd, o.congestion); err != nil {
+			return err
+		}
+	}
+	if o.deferAccept > 0 && isTCPNetwork(network) {
+		if err := setDeferAccept(fd, o.deferAccept); err != nil {
+			return err
+		}
+	}
+	if !o.reusePort {
+		return nil
+	}
+	share, err := o.reusePortNetwork(network)
+	if err != nil || !share {
+		return err
+	}
+	return setReusePort(fd)
+}
+
+// reusePortNetwork reports whether SO_REUSEPORT is set on sockets for
+// network. It is for TCP and UDP, and never for Unix domain sockets. Other
+// networks, such as the "ip4" of raw IP sockets, are left alone unless the
+// validator selected by WithNetworkValidator accepts them.
+func (o *options) reusePortNetwork(network string) (bool, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6":
+		return true, nil
+	case "unix", "unixgram", "unixpacket":
+		return false, nil
+	}
+	if o.networkValidator == nil {
+		return false, nil
+	}
+	if err := o.networkValidator(network); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func isTCPNetwork(network string) bool {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return true
+	}
+	return false
+}
+
+// isUnixNetwork reports whether network is a Unix domain socket network.
+// SO_REUSEPORT has no meaning for them and Linux rejects it with EOPNOTSUPP,
+// so it is skipped and they behave like plain Unix listeners.
+func isUnixNetwork(network string) bool {
+	switch network {
+	case "unix", "unixgram", "unixpacket":
+		return true
+	}
+	return false
+}
+
+// setBacklog calls listen(2) again on the already listening socket of l,
+// which resizes its accept queue.
+func setBacklog(l net.Listener, n int) error {
+	if err := controlListener(l, func(fd uintptr) error {
+		return listenBacklog(fd, n)
+	}); err != nil {
+		return fmt.Errorf("reuseport: set backlog: %w", err)
+	}
+	return nil
+}
+
+// controlListener runs f on the file descriptor of the open listener l.
+func controlListener(l net.Listener, f func(fd uintptr) error) error {
+	return controlFD(l, f)
+}
+
+// controlConn runs f on the file descriptor of the connection c, looking
+// through TLS connections.
+func controlConn(c net.Conn, f func(fd uintptr) error) error {
+	if nc, ok := c.(interface{ NetConn() net.Conn }); ok {
+		c = nc.NetConn()
+	}
+	return controlFD(c, f)
+}
+
+func controlFD(v any, f func(fd uintptr) error) error {
+	sc, ok := v.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("reuseport: %T has no file descriptor", v)
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var opErr error
+	if err := rc.Control(func(fd uintptr) {
+		opErr = f(fd)
+	}); err != nil {
+		return err
+	}
+	return opErr
+}
+
+// dupFile returns a duplicate of the socket of l that stays non-blocking.
+//
+// The *os.File returned by (*net.TCPListener).File switches to blocking mode
+// when its Fd method is called, which exec.Cmd does for every ExtraFiles
+// entry. Since the duplicate shares its file status flags with the original
+// socket, that would also make the accept loop of l block. A file created
+// with os.NewFile keeps the descriptor as it is.
+func dupFile(l net.Listener) (*os.File, error) {
+	var nfd int
+	if err := controlListener(l, func(fd uintptr) error {
+		var err error
+		nfd, err = dupCloseOnExec(fd)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(nfd), l.Addr().String()), nil
+}
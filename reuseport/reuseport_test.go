@@ -0,0 +1,19 @@
+package reuseport
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sock")
+	l, err := Listen(context.Background(), "unix", path, WithReadBuffer(1<<16))
+	if err != nil {
+		t.Fatalf("Listen(unix) = %v, want no setsockopt error", err)
+	}
+	defer l.Close()
+	if got := l.Addr().Network(); got != "unix" {
+		t.Errorf("Addr().Network() = %q, want unix", got)
+	}
+}
@@ -0,0 +1,32 @@
+//go:build unix
+
+package reuseport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestListenPortNotShareable(t *testing.T) {
+	// A socket without SO_REUSEPORT holds the port.
+	plain, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+
+	l, err := Listen(context.Background(), "tcp", plain.Addr().String())
+	if err == nil {
+		l.Close()
+		t.Fatal("Listen shared a port held without SO_REUSEPORT")
+	}
+	if !errors.Is(err, ErrPortNotShareable) {
+		t.Errorf("Listen = %v, want ErrPortNotShareable", err)
+	}
+	if !errors.Is(err, syscall.EADDRINUSE) {
+		t.Errorf("Listen = %v, want EADDRINUSE", err)
+	}
+}
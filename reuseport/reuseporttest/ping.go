@@ -0,0 +1,43 @@
+package reuseporttest
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Ping dials the address l is actually bound to, as reported by l.Addr, so
+// that it works with ephemeral ports, and closes the connection right away.
+// It returns an error if the connection cannot be established within timeout.
+//
+// The kernel completes the handshake before the connection is accepted, so
+// Ping shows that the socket is listening, not that an accept loop is
+// running. Within a reuseport group the connection may also be accepted by
+// any of the sockets, not necessarily l.
+func Ping(l net.Listener, timeout time.Duration) error {
+	addr := l.Addr()
+	c, err := net.DialTimeout(addr.Network(), addr.String(), timeout)
+	if err != nil {
+		return fmt.Errorf("reuseporttest: ping %s: %w", addr, err)
+	}
+	return c.Close()
+}
+
+// PingHTTP sends a GET request for / to the HTTP server at addr, such as the
+// Addr of a Sharder being served, and returns an error unless it answers with
+// a 2xx status within timeout.
+func PingHTTP(addr string, timeout time.Duration) error {
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get("http://" + addr + "/")
+	if err != nil {
+		return fmt.Errorf("reuseporttest: ping %s: %w", addr, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("reuseporttest: ping %s: unexpected status %s", addr, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+// Package reuseporttest provides helpers to check the behaviour of a
+// reuseport.Sharder from tests.
+package reuseporttest
+
+import (
+	"math"
+	"testing"
+
+	"github.com/douglasmakey/socket-sharding/reuseport"
+)
+
+// MinTotal is the number of accepted connections below which AssertBalanced
+// does not assert anything. With few connections the kernel's hash leaves
+// shards unevenly loaded by chance, so the check would only be noise.
+var MinTotal uint64 = 1000
+
+// AssertBalanced fails t if the accept count of any shard in stats deviates
+// from the mean by more than tolerance, as a fraction of the mean: with a
+// tolerance of 0.2 every shard must have accepted between 80% and 120% of the
+// mean. Stats with fewer than MinTotal accepts in total are only logged.
+func AssertBalanced(t testing.TB, stats []reuseport.ShardStat, tolerance float64) {
+	t.Helper()
+	if len(stats) == 0 {
+		t.Fatal("reuseporttest: no shard stats")
+	}
+
+	var total uint64
+	for _, st := range stats {
+		total += st.Accepted
+	}
+	if total < MinTotal {
+		t.Logf("reuseporttest: %d accepts across %d shards, need %d to check balance", total, len(stats), MinTotal)
+		return
+	}
+
+	mean := float64(total) / float64(len(stats))
+	for _, st := range stats {
+		dev := math.Abs(float64(st.Accepted)-mean) / mean
+		if dev > tolerance {
+			t.Errorf("reuseporttest: shard %d accepted %d connections, %.0f%% off the mean of %.1f (tolerance %.0f%%)",
+				st.Index, st.Accepted, dev*100, mean, tolerance*100)
+		}
+	}
+}
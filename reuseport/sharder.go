@@ -0,0 +1,590 @@
+package reuseport
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrPartialShards is matched by the error NewSharder returns along with a
+// usable Sharder when WithMinShards let it open fewer shards than requested.
+var ErrPartialShards = errors.New("reuseport: not all shards could be opened")
+
+// Sharder holds several TCP listeners bound to the same address, each on its
+// own SO_REUSEPORT socket, so that every shard can run an independent accept
+// loop inside a single process.
+type Sharder struct {
+	opts     *options
+	addr     string
+	counters []*shardCounters
+	cpus     []int
+	notReady atomic.Bool
+
+	mu         sync.Mutex
+	listeners  []*instrumentedListener
+	servers    []*http.Server
+	handled    chan struct{}
+	done       chan struct{}
+	runShard   func(i int, l net.Listener) error
+	results    chan error
+	running    int
+	serving    bool
+	inShutdown bool
+}
+
+// NewSharder opens n listeners on addr, applying opts to each of them, except
+// for WithReusePortBPF which only applies to shard 0. If any of them fails to
+// open, the ones already opened are closed and the error is returned, unless
+// WithMinShards allows a smaller group.
+//
+// If addr has port 0, shard 0 is bound to an ephemeral port chosen by the
+// kernel and the remaining shards are explicitly bound to that same port, so
+// that all of them join a single reuseport group. Binding each of them to
+// port 0 would give every shard its own port instead.
+//
+// On Linux and FreeBSD, NewSharder fails fast if CheckKernelSupport reports
+// that the kernel would not balance connections across the shards. On Unix
+// systems it also fails fast if the shards, plus some spare file descriptors,
+// would not fit within the soft RLIMIT_NOFILE; see WithRaiseFDLimit.
+func NewSharder(n int, addr string, opts ...SharderOption) (*Sharder, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("reuseport: invalid shard count %d", n)
+	}
+	if runtime.GOOS == "linux" || runtime.GOOS == "freebsd" {
+		if err := CheckKernelSupport(); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &Sharder{
+		opts:     newSharderOptions(opts),
+		counters: make([]*shardCounters, n),
+		cpus:     allowedCPUs(),
+	}
+	for i := range s.counters {
+		s.counters[i] = &shardCounters{}
+		if m := s.opts.maxConns; m > 0 {
+			s.counters[i].slots = make(chan struct{}, m)
+		}
+	}
+	min := n
+	if m := s.opts.minShards; m > 0 && m < n {
+		min = m
+	}
+	if err := checkFDLimit(min, s.opts.raiseFDLimit); err != nil {
+		return nil, err
+	}
+	ls, addr, err := s.open(addr, min)
+	if ls == nil {
+		return nil, err
+	}
+	s.counters = s.counters[:len(ls)]
+	s.listeners = ls
+	s.addr = addr
+	return s, err
+}
+
+// open opens one listener per shard on addr. It returns them along with addr
+// with port 0 resolved to the port chosen for shard 0.
+//
+// If a shard fails to open once at least min shards are open, the ones
+// opened so far are returned along with an ErrPartialShards error.
+func (s *Sharder) open(addr string, min int) ([]*instrumentedListener, string, error) {
+	ls := make([]*instrumentedListener, 0, len(s.counters))
+	for i, c := range s.counters {
+		so := *s.opts
+		if so.cpuAffinity {
+			so.incomingCPU = s.shardCPU(i)
+			so.incomingCPUSet = true
+		}
+		if i > 0 {
+			// The BPF program is shared by the whole group.
+			so.attachBPF = nil
+		}
+		l, err := so.listen(context.Background(), "tcp", addr)
+		if err != nil {
+			if i >= min {
+				return ls, addr, fmt.Errorf("%w: opened %d of %d: %w", ErrPartialShards, i, len(s.counters), err)
+			}
+			closeAll(ls)
+			return nil, "", fmt.Errorf("reuseport: open shard %d: %w", i, err)
+		}
+		ls = append(ls, newInstrumentedListener(l, c, so.linger))
+		s.logf("reuseport: shard %d listening on %s", i, l.Addr())
+
+		if i == 0 {
+			if addr, err = resolvePort(addr, l.Addr()); err != nil {
+				closeAll(ls)
+				return nil, "", err
+			}
+		}
+	}
+	return ls, addr, nil
+}
+
+// shardCPU returns the CPU shard i is assigned to by WithCPUAffinity and
+// WithLockedThreads: the i-th of the CPUs the process was allowed to run on
+// when s was created.
+func (s *Sharder) shardCPU(i int) int {
+	if s.opts.incomingCPUSet && !s.opts.cpuAffinity {
+		return s.opts.incomingCPU
+	}
+	return s.cpus[i%len(s.cpus)]
+}
+
+// resolvePort returns addr with its port replaced by the port of bound, the
+// address shard 0 actually bound to.
+func resolvePort(addr string, bound net.Addr) (string, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	_, port, err := net.SplitHostPort(bound.String())
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// shards returns the current shard listeners.
+func (s *Sharder) shards() []*instrumentedListener {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listeners
+}
+
+// Len returns the number of shards, which is lower than requested when
+// NewSharder opened a partial group under WithMinShards.
+func (s *Sharder) Len() int {
+	return len(s.counters)
+}
+
+// Addr returns the address shared by all the shards. When the Sharder was
+// created with port 0, it reports the port chosen by the kernel.
+func (s *Sharder) Addr() net.Addr {
+	return s.shards()[0].Addr()
+}
+
+// Listeners returns the shard listeners, one per shard, in shard order.
+func (s *Sharder) Listeners() []net.Listener {
+	shards := s.shards()
+	ls := make([]net.Listener, len(shards))
+	for i, l := range shards {
+		ls[i] = l
+	}
+	return ls
+}
+
+// Files returns duplicates of the shard sockets, in shard order, suitable for
+// exec.Cmd.ExtraFiles. A child process can pass each of them to
+// ListenFromFile and serve it immediately, which allows zero-downtime binary
+// upgrades. The files stay non-blocking, so passing them to a child does not
+// affect the accept loops of this process. The caller must close them.
+func (s *Sharder) Files() ([]*os.File, error) {
+	shards := s.shards()
+	files := make([]*os.File, 0, len(shards))
+	for i, l := range shards {
+		f, err := dupFile(l.Listener)
+		if err != nil {
+			for _, f := range files {
+				f.Close()
+			}
+			return nil, fmt.Errorf("reuseport: dup shard %d: %w", i, err)
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// ListenerFor returns the index of the shard that accepted conn, or -1 if
+// conn was not accepted by one of the shards of s. TLS connections are looked
+// through, so it also works from the ConnContext hook of a server started by
+// ServeTLS. The index is the one used by Stats and DrainShard, and is kept
+// across Reload.
+func (s *Sharder) ListenerFor(conn net.Conn) int {
+	tc, ok := conn.(*trackedConn)
+	// Look through TLS connections, which wrap the tracked connection.
+	for !ok {
+		nc, isWrapper := conn.(interface{ NetConn() net.Conn })
+		if !isWrapper {
+			return -1
+		}
+		conn = nc.NetConn()
+		tc, ok = conn.(*trackedConn)
+	}
+	for i, c := range s.counters {
+		if tc.active == &c.active {
+			return i
+		}
+	}
+	return -1
+}
+
+// ShardStat reports the number of connections accepted by a single shard.
+type ShardStat struct {
+	Index    int
+	Accepted uint64
+}
+
+// Stats returns the accept counters of every shard, in shard order. It is
+// safe to call while the shards are accepting connections. The counters are
+// kept across Reload.
+func (s *Sharder) Stats() []ShardStat {
+	stats := make([]ShardStat, len(s.counters))
+	for i, c := range s.counters {
+		stats[i] = ShardStat{Index: i, Accepted: c.accepted.Load()}
+	}
+	return stats
+}
+
+// ActiveConns returns the number of connections accepted by the shards that
+// have not been closed yet, for example to report how many connections are
+// still draining during Shutdown.
+func (s *Sharder) ActiveConns() int64 {
+	var n int64
+	for _, c := range s.counters {
+		n += c.active.Load()
+	}
+	return n
+}
+
+// Serve runs one http.Server per shard, all using the handler h, and blocks
+// until every server has stopped accepting. If any shard fails, the remaining
+// servers are closed and the first error is returned; http.ErrServerClosed
+// and ErrDraining are not considered failures, so Serve returns nil after a
+// clean Shutdown.
+//
+// Like http.Server.Serve, Serve returns as soon as Shutdown starts, while the
+// connections already accepted are still being served. A program that wants
+// them to finish must wait for Shutdown to return before exiting.
+//
+// Serve returns http.ErrServerClosed if it is called after Shutdown.
+func (s *Sharder) Serve(h http.Handler) error {
+	return s.ServeWithServer(&http.Server{}, h)
+}
+
+// ServeWithServer is like Serve, but every shard server is a copy of the
+// configuration of tmpl, such as its ReadTimeout, WriteTimeout and
+// IdleTimeout. The server handler is tmpl.Handler if set, h otherwise.
+// tmpl itself is never started.
+func (s *Sharder) ServeWithServer(tmpl *http.Server, h http.Handler) error {
+	if tmpl.Handler != nil {
+		h = tmpl.Handler
+	}
+	return s.serve(func() *http.Server {
+		srv := cloneServer(tmpl)
+		srv.Handler = h
+		return srv
+	}, (*http.Server).Serve)
+}
+
+// cloneServer returns a new http.Server with the configuration of tmpl. The
+// struct cannot be copied as a whole since it holds its own state and locks.
+func cloneServer(tmpl *http.Server) *http.Server {
+	return &http.Server{
+		Addr:                         tmpl.Addr,
+		Handler:                      tmpl.Handler,
+		DisableGeneralOptionsHandler: tmpl.DisableGeneralOptionsHandler,
+		TLSConfig:                    tmpl.TLSConfig,
+		ReadTimeout:                  tmpl.ReadTimeout,
+		ReadHeaderTimeout:            tmpl.ReadHeaderTimeout,
+		WriteTimeout:                 tmpl.WriteTimeout,
+		IdleTimeout:                  tmpl.IdleTimeout,
+		MaxHeaderBytes:               tmpl.MaxHeaderBytes,
+		TLSNextProto:                 tmpl.TLSNextProto,
+		ConnState:                    tmpl.ConnState,
+		ErrorLog:                     tmpl.ErrorLog,
+		BaseContext:                  tmpl.BaseContext,
+		ConnContext:                  tmpl.ConnContext,
+	}
+}
+
+// ServeTLS is like Serve but terminates TLS on every shard with the
+// certificate and key loaded once from certFile and keyFile.
+func (s *Sharder) ServeTLS(h http.Handler, certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+	return s.serve(func() *http.Server {
+		return &http.Server{Handler: h, TLSConfig: cfg}
+	}, func(srv *http.Server, l net.Listener) error {
+		return srv.ServeTLS(l, "", "")
+	})
+}
+
+func (s *Sharder) serve(newServer func() *http.Server, serve func(*http.Server, net.Listener) error) error {
+	// The shard count never changes: Reload serves the new sockets with the
+	// servers of the shards they replace.
+	servers := make([]*http.Server, len(s.counters))
+	for i := range servers {
+		servers[i] = newServer()
+	}
+	return s.run(servers, nil, func(i int, l net.Listener) error {
+		return serve(servers[i], l)
+	}, func() {
+		for _, srv := range servers {
+			srv.Close()
+		}
+	})
+}
+
+// checkServe reports whether the Sharder can start serving. s.mu must be
+// held.
+func (s *Sharder) checkServe() error {
+	if s.inShutdown {
+		return http.ErrServerClosed
+	}
+	if s.serving {
+		return errors.New("reuseport: Sharder is already serving")
+	}
+	return nil
+}
+
+// run runs runShard on every shard listener, each in its own goroutine, and
+// blocks until all of them have returned. On the first error other than
+// http.ErrServerClosed and ErrDraining, stop is called to make the others
+// return, and that error is returned.
+//
+// servers and handled are recorded for Shutdown and Close: the servers the
+// shards are served with, if any, and a channel closed once the connections
+// handed off by the shards have been handled, if any. The check that s is
+// not serving or shut down, recording them and starting the shards all
+// happen under one lock, so that concurrent calls cannot both start and
+// Shutdown always sees either no shard or all of them running.
+func (s *Sharder) run(servers []*http.Server, handled chan struct{}, runShard func(i int, l net.Listener) error, stop func()) error {
+	s.mu.Lock()
+	if err := s.checkServe(); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	s.servers = servers
+	s.handled = handled
+	s.done = done
+	s.runShard = runShard
+	s.results = make(chan error)
+	s.serving = true
+	for i, l := range s.listeners {
+		s.startShard(i, l)
+	}
+	results := s.results
+	s.mu.Unlock()
+
+	// Reload may start more shards while we wait, so count them under s.mu
+	// rather than up front.
+	var first error
+	for {
+		s.mu.Lock()
+		if s.running == 0 {
+			s.serving = false
+			s.mu.Unlock()
+			return first
+		}
+		s.mu.Unlock()
+
+		err := <-results
+		s.mu.Lock()
+		s.running--
+		s.mu.Unlock()
+
+		if err == nil || errors.Is(err, http.ErrServerClosed) || errors.Is(err, ErrDraining) || first != nil {
+			continue
+		}
+		first = err
+		stop()
+	}
+}
+
+// startShard runs s.runShard on l, the listener of shard i, in a new
+// goroutine. s.mu must be held.
+func (s *Sharder) startShard(i int, l net.Listener) {
+	runShard, results := s.runShard, s.results
+	s.running++
+	s.logf("reuseport: shard %d serving on %s", i, l.Addr())
+	go func() {
+		var err error
+		if s.opts.lockedThreads {
+			err = lockThread(s.shardCPU(i))
+		}
+		if err == nil {
+			err = runShard(i, l)
+		}
+		s.logf("reuseport: shard %d stopped serving on %s: %v", i, l.Addr(), err)
+		results <- err
+	}()
+}
+
+// DrainShard stops shard i from accepting new connections while the
+// connections it already accepted keep being served. The shard socket is
+// closed so that the kernel routes new connections to the other shards;
+// connections still waiting in its accept queue are reset unless
+// net.ipv4.tcp_migrate_req is enabled. The /readyz probe of HealthHandler
+// answers 503 from then on, as after MarkNotReady, even though the other
+// shards keep accepting.
+//
+// The kernel moves the last socket of the reuseport group into the slot of
+// the drained one, so afterwards group index i no longer means shard i: a
+// WithReusePortBPF program that returns shard indexes, such as SteerByCPU,
+// no longer steers as intended.
+func (s *Sharder) DrainShard(i int) error {
+	shards := s.shards()
+	if i < 0 || i >= len(shards) {
+		return fmt.Errorf("reuseport: shard %d out of range", i)
+	}
+	s.MarkNotReady()
+	s.logf("reuseport: shard %d draining on %s", i, shards[i].Addr())
+	return shards[i].Drain()
+}
+
+// Reload opens a new socket for every shard on the same address and with the
+// same options, swaps them in for the current ones and drains the old ones.
+// SO_REUSEPORT lets both sets share the port in the meantime, and the new
+// sockets are served before the old ones stop accepting, so there is no
+// moment at which no shard is accepting. As with DrainShard, connections
+// still queued on an old socket when it is drained are reset unless
+// net.ipv4.tcp_migrate_req is enabled. Once Reload returns, the new sockets
+// have the indexes of the shards they replace in the reuseport group, as seen
+// by a WithReusePortBPF program.
+func (s *Sharder) Reload() error {
+	ls, _, err := s.open(s.addr, len(s.counters))
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.inShutdown {
+		s.mu.Unlock()
+		closeAll(ls)
+		return http.ErrServerClosed
+	}
+	old := s.listeners
+	s.listeners = ls
+	if s.serving {
+		for i, l := range ls {
+			s.startShard(i, l)
+		}
+	}
+	s.mu.Unlock()
+
+	// When a socket leaves a reuseport group, the kernel moves the last
+	// socket of the group into its slot. Draining the old sockets from the
+	// last one down leaves the new ones at the indexes of the shards they
+	// replace, so that BPF programs returning a shard index keep working.
+	var errs []error
+	for i := len(old) - 1; i >= 0; i-- {
+		l := old[i]
+		s.logf("reuseport: shard %d draining on %s after reload", i, l.Addr())
+		if err := l.Drain(); err != nil {
+			errs = append(errs, fmt.Errorf("reuseport: drain shard %d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Shutdown gracefully shuts down every shard server concurrently, waiting for
+// active connections to finish. If ctx expires first, the servers that have
+// not finished are closed and the combined error is returned. Shutdown closes
+// the listeners directly if Serve was never called. After Handle, it closes
+// the listeners and waits for the workers to finish the queued connections.
+func (s *Sharder) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.inShutdown = true
+	servers := s.servers
+	handled := s.handled
+	listeners := s.listeners
+	s.mu.Unlock()
+
+	if servers == nil {
+		for i, l := range listeners {
+			s.logf("reuseport: shard %d closing on %s", i, l.Addr())
+		}
+		err := closeAll(listeners)
+		if handled != nil {
+			select {
+			case <-handled:
+			case <-ctx.Done():
+				err = errors.Join(err, fmt.Errorf("reuseport: shutdown workers: %w", ctx.Err()))
+			}
+		}
+		return err
+	}
+
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i, srv := range servers {
+		wg.Add(1)
+		go func(i int, srv *http.Server) {
+			defer wg.Done()
+			s.logf("reuseport: shard %d shutting down on %s", i, s.addr)
+			if err := srv.Shutdown(ctx); err != nil {
+				errs[i] = fmt.Errorf("reuseport: shutdown shard %d: %w", i, errors.Join(err, srv.Close()))
+				s.logf("reuseport: shard %d shut down on %s: %v", i, s.addr, errs[i])
+				return
+			}
+			s.logf("reuseport: shard %d shut down on %s", i, s.addr)
+		}(i, srv)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Close closes every shard listener and returns once the shard goroutines
+// started by Serve, ServeTLS or Handle have returned. Unlike Shutdown it does
+// not wait for connections to finish: the servers started by Serve and
+// ServeTLS are closed along with their connections, while Handle still waits
+// for its workers to finish the queued connections. For accept loops run on
+// the Listeners, such as AcceptLoop, Close returns once no Accept call is
+// pending on any shard, so the loops only have to notice the error. The
+// errors of every shard are joined.
+func (s *Sharder) Close() error {
+	s.mu.Lock()
+	s.inShutdown = true
+	servers := s.servers
+	handled := s.handled
+	done := s.done
+	listeners := s.listeners
+	s.mu.Unlock()
+
+	var errs []error
+	for i, srv := range servers {
+		if err := srv.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("reuseport: close shard %d server: %w", i, err))
+		}
+	}
+	for i, l := range listeners {
+		s.logf("reuseport: shard %d closing on %s", i, l.Addr())
+		// Closing the listener waits for its pending Accept calls to return.
+		if err := l.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, fmt.Errorf("reuseport: close shard %d: %w", i, err))
+		}
+	}
+	if done != nil {
+		<-done
+	}
+	if handled != nil {
+		<-handled
+	}
+	return errors.Join(errs...)
+}
+
+// closeAll closes every listener of ls. Listeners closed before, such as by
+// an earlier Shutdown, are not reported.
+func closeAll(ls []*instrumentedListener) error {
+	var errs []error
+	for i, l := range ls {
+		if err := l.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, fmt.Errorf("reuseport: close shard %d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,260 @@
+package reuseport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func newTestSharder(t *testing.T, n int, opts ...SharderOption) *Sharder {
+	t.Helper()
+	s, err := NewSharder(n, "127.0.0.1:0", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func port(t *testing.T, addr net.Addr) string {
+	t.Helper()
+	_, p, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// waitFor polls cond until it reports true, failing the test after a second.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func accepted(s *Sharder) uint64 {
+	var n uint64
+	for _, st := range s.Stats() {
+		n += st.Accepted
+	}
+	return n
+}
+
+func TestNewSharderPortZero(t *testing.T) {
+	s := newTestSharder(t, 4)
+
+	want := port(t, s.Addr())
+	if want == "0" {
+		t.Fatalf("Addr() = %v, want the port chosen by the kernel", s.Addr())
+	}
+	for i, l := range s.Listeners() {
+		if got := port(t, l.Addr()); got != want {
+			t.Errorf("shard %d listens on port %s, want %s", i, got, want)
+		}
+	}
+
+	// Reload opens the new sockets on the resolved port, not on a new one.
+	if err := s.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	for i, l := range s.Listeners() {
+		if got := port(t, l.Addr()); got != want {
+			t.Errorf("after Reload, shard %d listens on port %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestServeConcurrent(t *testing.T) {
+	s := newTestSharder(t, 2)
+	h := http.NotFoundHandler()
+
+	errc := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { errc <- s.Serve(h) }()
+	}
+	// One of the calls must be turned down while the other one serves.
+	if err := <-errc; err == nil || errors.Is(err, http.ErrServerClosed) {
+		t.Fatalf("concurrent Serve = %v, want an already serving error", err)
+	}
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("Serve = %v, want nil after Shutdown", err)
+	}
+}
+
+func TestShutdownWhileStarting(t *testing.T) {
+	// Shutdown may run before, while or after Serve starts the shards: in
+	// every case the shard sockets must end up closed.
+	for i := 0; i < 20; i++ {
+		s := newTestSharder(t, 2)
+		addr := s.Addr().String()
+		errc := make(chan error, 1)
+		go func() { errc <- s.Serve(http.NotFoundHandler()) }()
+		if err := s.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err := <-errc; err != nil && !errors.Is(err, http.ErrServerClosed) {
+			t.Fatalf("Serve = %v", err)
+		}
+		if c, err := net.Dial("tcp", addr); err == nil {
+			c.Close()
+			t.Fatalf("iteration %d: %s still accepts connections after Shutdown", i, addr)
+		}
+	}
+}
+
+func TestCloseLeavesNoGoroutines(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		serve func(s *Sharder) error
+	}{
+		{"Serve", func(s *Sharder) error {
+			return s.Serve(http.NotFoundHandler())
+		}},
+		{"Handle", func(s *Sharder) error {
+			return s.Handle(func(c net.Conn) { c.Close() })
+		}},
+		{"AcceptLoop", func(s *Sharder) error {
+			return s.AcceptLoop(context.Background(), func(c net.Conn) { c.Close() })
+		}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			before := runtime.NumGoroutine()
+			s, err := NewSharder(4, "127.0.0.1:0", WithWorkers(4))
+			if err != nil {
+				t.Fatal(err)
+			}
+			errc := make(chan error, 1)
+			go func() { errc <- tt.serve(s) }()
+
+			c, err := net.Dial("tcp", s.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+			waitFor(t, "the connection to be accepted", func() bool { return accepted(s) == 1 })
+
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := <-errc; err != nil && !errors.Is(err, http.ErrServerClosed) {
+				t.Fatalf("%s = %v", tt.name, err)
+			}
+			// Goroutines that have been told to stop may take a moment to
+			// be reaped, but none may be left for good.
+			waitFor(t, "the sharder goroutines to exit", func() bool {
+				return runtime.NumGoroutine() <= before
+			})
+		})
+	}
+}
+
+// dialN opens n connections to addr, which the kernel completes on its own
+// and queues until they are accepted.
+func dialN(t *testing.T, addr string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+	}
+}
+
+func TestWithMaxConns(t *testing.T) {
+	const max, extra = 2, 3
+	s := newTestSharder(t, 1, WithMaxConns(max))
+	l := s.Listeners()[0]
+
+	conns := make(chan net.Conn, max+extra)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns <- c
+		}
+	}()
+	dialN(t, s.Addr().String(), max+extra)
+
+	var open []net.Conn
+	for i := 0; i < max; i++ {
+		open = append(open, <-conns)
+	}
+	// The other connections stay queued in the kernel while no slot is free.
+	select {
+	case <-conns:
+		t.Fatalf("accepted more than %d connections at once", max)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Every connection closed frees a slot for one queued connection.
+	for i := 0; i < extra; i++ {
+		open[0].Close()
+		open = append(open[1:], <-conns)
+		select {
+		case <-conns:
+			t.Fatalf("accepted more than %d connections at once", max)
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	for _, c := range open {
+		c.Close()
+	}
+	if got := accepted(s); got != max+extra {
+		t.Errorf("accepted %d connections, want %d", got, max+extra)
+	}
+}
+
+func TestWithMaxConnsWakeUp(t *testing.T) {
+	// acceptBlocked returns a shard listener with its only slot taken, so
+	// that Accept waits for a slot.
+	acceptBlocked := func(t *testing.T) (*Sharder, net.Listener) {
+		s := newTestSharder(t, 1, WithMaxConns(1))
+		l := s.Listeners()[0]
+		dialN(t, s.Addr().String(), 2)
+		c, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return s, l
+	}
+
+	t.Run("deadline", func(t *testing.T) {
+		_, l := acceptBlocked(t)
+		l.(deadlineListener).SetDeadline(time.Now().Add(50 * time.Millisecond))
+		if _, err := l.Accept(); !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("Accept = %v, want a deadline error", err)
+		}
+	})
+
+	t.Run("context", func(t *testing.T) {
+		_, l := acceptBlocked(t)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if _, err := AcceptContext(ctx, l); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("AcceptContext = %v, want context.DeadlineExceeded", err)
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		s, l := acceptBlocked(t)
+		time.AfterFunc(50*time.Millisecond, func() { s.Close() })
+		if _, err := l.Accept(); !errors.Is(err, net.ErrClosed) {
+			t.Fatalf("Accept = %v, want net.ErrClosed", err)
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package reuseport
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+func setIncomingCPU(fd uintptr, cpu int) error {
+	return unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_INCOMING_CPU, cpu)
+}
+
+func setDeferAccept(fd uintptr, seconds int) error {
+	return unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_DEFER_ACCEPT, seconds)
+}
+
+func bindToDevice(fd uintptr, iface string) error {
+	err := unix.BindToDevice(int(fd), iface)
+	if errors.Is(err, unix.EPERM) {
+		return fmt.Errorf("reuseport: SO_BINDTODEVICE %s requires CAP_NET_RAW: %w", iface, err)
+	}
+	if err != nil {
+		return fmt.Errorf("reuseport: SO_BINDTODEVICE %s: %w", iface, err)
+	}
+	return nil
+}
+
+func setCongestion(fd uintptr, algo string) error {
+	err := unix.SetsockoptString(int(fd), unix.IPPROTO_TCP, unix.TCP_CONGESTION, algo)
+	switch {
+	case errors.Is(err, unix.ENOENT):
+		return fmt.Errorf("reuseport: TCP congestion control %q is not available in this kernel: %w", algo, err)
+	case errors.Is(err, unix.EPERM):
+		return fmt.Errorf("reuseport: TCP congestion control %q is not allowed for this process: %w", algo, err)
+	case err != nil:
+		return fmt.Errorf("reuseport: TCP_CONGESTION %q: %w", algo, err)
+	}
+	return nil
+}
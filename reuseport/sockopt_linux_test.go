@@ -0,0 +1,298 @@
+package reuseport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// getsockoptInt reads an integer socket option from the socket of v, a
+// listener or connection.
+func getsockoptInt(t *testing.T, v any, level, opt int) int {
+	t.Helper()
+	var n int
+	if err := controlFD(v, func(fd uintptr) error {
+		var err error
+		n, err = unix.GetsockoptInt(int(fd), level, opt)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func listen(t *testing.T, opts ...ListenOption) net.Listener {
+	t.Helper()
+	l, err := Listen(context.Background(), "tcp", "127.0.0.1:0", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestWithBacklog(t *testing.T) {
+	l := listen(t, WithBacklog(17))
+	// For a listening socket, tcpi_sacked reports the accept queue length.
+	var info *unix.TCPInfo
+	if err := controlListener(l, func(fd uintptr) error {
+		var err error
+		info, err = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if info.Sacked != 17 {
+		t.Errorf("accept queue length = %d, want 17", info.Sacked)
+	}
+}
+
+func TestWithIncomingCPU(t *testing.T) {
+	l := listen(t, WithIncomingCPU(0))
+	if got := getsockoptInt(t, l, unix.SOL_SOCKET, unix.SO_INCOMING_CPU); got != 0 {
+		t.Errorf("SO_INCOMING_CPU = %d, want 0", got)
+	}
+}
+
+func TestWithCPUAffinity(t *testing.T) {
+	var set unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &set); err != nil {
+		t.Fatal(err)
+	}
+	var cpus []int
+	for cpu := 0; len(cpus) < set.Count(); cpu++ {
+		if set.IsSet(cpu) {
+			cpus = append(cpus, cpu)
+		}
+	}
+
+	// More shards than CPUs, so that the assignment wraps around.
+	n := 2*len(cpus) + 1
+	s := newTestSharder(t, n, WithCPUAffinity())
+	for i, l := range s.shards() {
+		want := cpus[i%len(cpus)]
+		if got := getsockoptInt(t, l.Listener, unix.SOL_SOCKET, unix.SO_INCOMING_CPU); got != want {
+			t.Errorf("shard %d: SO_INCOMING_CPU = %d, want %d", i, got, want)
+		}
+	}
+
+	// Confined to its last CPU, as in a cpuset, a thread gets every shard
+	// assigned to that CPU rather than to CPU 0.
+	last := cpus[len(cpus)-1]
+	type result struct {
+		s   *Sharder
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		// The thread is never unlocked, so the runtime discards it along
+		// with its affinity once the goroutine exits.
+		runtime.LockOSThread()
+		var set unix.CPUSet
+		set.Set(last)
+		if err := unix.SchedSetaffinity(0, &set); err != nil {
+			done <- result{err: err}
+			return
+		}
+		s, err := NewSharder(2, "127.0.0.1:0", WithCPUAffinity())
+		done <- result{s, err}
+	}()
+	r := <-done
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	defer r.s.Close()
+	for i, l := range r.s.shards() {
+		if got := getsockoptInt(t, l.Listener, unix.SOL_SOCKET, unix.SO_INCOMING_CPU); got != last {
+			t.Errorf("confined to CPU %d, shard %d: SO_INCOMING_CPU = %d", last, i, got)
+		}
+	}
+}
+
+func TestWithReadWriteBuffer(t *testing.T) {
+	const size = 32 << 10
+	l := listen(t, WithReadBuffer(size), WithWriteBuffer(size))
+	// Linux doubles the value to account for bookkeeping overhead.
+	if got := getsockoptInt(t, l, unix.SOL_SOCKET, unix.SO_RCVBUF); got != 2*size {
+		t.Errorf("SO_RCVBUF = %d, want %d", got, 2*size)
+	}
+	if got := getsockoptInt(t, l, unix.SOL_SOCKET, unix.SO_SNDBUF); got != 2*size {
+		t.Errorf("SO_SNDBUF = %d, want %d", got, 2*size)
+	}
+}
+
+func TestWithDeferAccept(t *testing.T) {
+	l := listen(t, WithDeferAccept(5))
+	// The kernel stores the timeout as a number of SYN-ACK retransmits and
+	// reports the seconds they add up to, 7 for 5.
+	if got := getsockoptInt(t, l, unix.IPPROTO_TCP, unix.TCP_DEFER_ACCEPT); got < 5 {
+		t.Errorf("TCP_DEFER_ACCEPT = %d, want at least 5", got)
+	}
+}
+
+// acceptOne dials l and returns the connection it accepts.
+func acceptOne(t *testing.T, l net.Listener) net.Conn {
+	t.Helper()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	ac, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ac.Close() })
+	return ac
+}
+
+func TestWithLinger(t *testing.T) {
+	retry := func(t *testing.T, opts ...ListenOption) net.Listener {
+		l, err := ListenWithRetry(context.Background(), "tcp", "127.0.0.1:0", 1, 0, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { l.Close() })
+		return l
+	}
+	shard := func(t *testing.T, opts ...ListenOption) net.Listener {
+		sopts := make([]SharderOption, len(opts))
+		for i, opt := range opts {
+			sopts[i] = opt
+		}
+		return newTestSharder(t, 1, sopts...).Listeners()[0]
+	}
+
+	for _, tt := range []struct {
+		name   string
+		listen func(*testing.T, ...ListenOption) net.Listener
+	}{
+		{"Listen", listen},
+		{"ListenWithRetry", retry},
+		{"Sharder", shard},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			l := tt.listen(t, WithLinger(1500*time.Millisecond))
+			// The listener keeps its deadline and file descriptor.
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			if _, err := AcceptContext(ctx, l); !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("AcceptContext = %v, want context.DeadlineExceeded", err)
+			}
+			if err := controlListener(l, func(fd uintptr) error { return nil }); err != nil {
+				t.Fatal(err)
+			}
+
+			// Rounded up to a whole second.
+			c := acceptOne(t, l)
+			var lg *unix.Linger
+			if err := controlFD(c, func(fd uintptr) error {
+				var err error
+				lg, err = unix.GetsockoptLinger(int(fd), unix.SOL_SOCKET, unix.SO_LINGER)
+				return err
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if lg.Onoff == 0 || lg.Linger != 2 {
+				t.Errorf("SO_LINGER = {Onoff: %d, Linger: %d}, want {1, 2}", lg.Onoff, lg.Linger)
+			}
+		})
+	}
+}
+
+func TestWithCongestionControl(t *testing.T) {
+	l, err := Listen(context.Background(), "tcp", "127.0.0.1:0", WithCongestionControl("bbr"))
+	if errors.Is(err, unix.ENOENT) || errors.Is(err, unix.EPERM) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var algo string
+	if err := controlListener(l, func(fd uintptr) error {
+		var err error
+		algo, err = unix.GetsockoptString(int(fd), unix.IPPROTO_TCP, unix.TCP_CONGESTION)
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// The name is padded with NULs to the size of the kernel buffer.
+	if algo = strings.TrimRight(algo, "\x00"); algo != "bbr" {
+		t.Errorf("TCP_CONGESTION = %q, want bbr", algo)
+	}
+}
+
+func TestWrapListenConfig(t *testing.T) {
+	reusePort := func(fd uintptr) int {
+		v, err := unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT)
+		if err != nil {
+			t.Error(err)
+		}
+		return v
+	}
+
+	t.Run("same Control call", func(t *testing.T) {
+		var before, after int
+		base := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+			if err := c.Control(func(fd uintptr) { before = reusePort(fd) }); err != nil {
+				return err
+			}
+			// A second call sees what the first one left behind.
+			return c.Control(func(fd uintptr) { after = reusePort(fd) })
+		}}
+		lc := WrapListenConfig(base, WithReadBuffer(32<<10))
+		l, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		if before != 0 || after != 1 {
+			t.Errorf("SO_REUSEPORT = %d in the callback and %d after it, want 0 and 1", before, after)
+		}
+		if got := getsockoptInt(t, l, unix.SOL_SOCKET, unix.SO_RCVBUF); got != 64<<10 {
+			t.Errorf("SO_RCVBUF = %d, want %d", got, 64<<10)
+		}
+	})
+
+	t.Run("base without Control call", func(t *testing.T) {
+		base := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+			return nil
+		}}
+		lc := WrapListenConfig(base)
+		l, err := lc.Listen(context.Background(), "tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		if got := getsockoptInt(t, l, unix.SOL_SOCKET, unix.SO_REUSEPORT); got != 1 {
+			t.Errorf("SO_REUSEPORT = %d, want 1", got)
+		}
+	})
+}
+
+func TestNewSharderIPv6Only(t *testing.T) {
+	// IPv4 addresses are left alone rather than rejected.
+	newTestSharder(t, 2, WithIPv6Only(true))
+
+	for _, enabled := range []bool{false, true} {
+		s, err := NewSharder(2, ":0", WithIPv6Only(enabled))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		for i, l := range s.shards() {
+			if got := getsockoptInt(t, l, unix.IPPROTO_IPV6, unix.IPV6_V6ONLY); got != boolint(enabled) {
+				t.Errorf("WithIPv6Only(%t), shard %d: IPV6_V6ONLY = %d", enabled, i, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package reuseport
+
+import "fmt"
+
+func linuxOnly(opt string) error {
+	return fmt.Errorf("reuseport: %s is only supported on Linux", opt)
+}
+
+func setIncomingCPU(fd uintptr, cpu int) error {
+	return linuxOnly("SO_INCOMING_CPU")
+}
+
+func bindToDevice(fd uintptr, iface string) error {
+	return linuxOnly("SO_BINDTODEVICE")
+}
+
+func setCongestion(fd uintptr, algo string) error {
+	return linuxOnly("TCP_CONGESTION")
+}
+
+func setDeferAccept(fd uintptr, seconds int) error {
+	return linuxOnly("TCP_DEFER_ACCEPT")
+}
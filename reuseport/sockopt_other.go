@@ -0,0 +1,27 @@
+//go:build !unix
+
+package reuseport
+
+func setReuseAddr(fd uintptr, enabled bool) error {
+	return errUnsupported
+}
+
+func setReadBuffer(fd uintptr, bytes int) error {
+	return errUnsupported
+}
+
+func setWriteBuffer(fd uintptr, bytes int) error {
+	return errUnsupported
+}
+
+func setIPv6Only(fd uintptr, enabled bool) error {
+	return errUnsupported
+}
+
+func listenBacklog(fd uintptr, n int) error {
+	return errUnsupported
+}
+
+func dupCloseOnExec(fd uintptr) (int, error) {
+	return -1, errUnsupported
+}
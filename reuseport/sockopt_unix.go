@@ -0,0 +1,52 @@
+//go:build unix
+
+package reuseport
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// isAddrInUse reports whether err is a bind failing because the address is
+// already in use.
+func isAddrInUse(err error) bool {
+	return errors.Is(err, unix.EADDRINUSE)
+}
+
+// isAddrNotAvail reports whether err is a bind failing because the address
+// is not available, such as an address not assigned to any interface yet.
+func isAddrNotAvail(err error) bool {
+	return errors.Is(err, unix.EADDRNOTAVAIL)
+}
+
+func setReuseAddr(fd uintptr, enabled bool) error {
+	return unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, boolint(enabled))
+}
+
+func setReadBuffer(fd uintptr, bytes int) error {
+	return unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_RCVBUF, bytes)
+}
+
+func setWriteBuffer(fd uintptr, bytes int) error {
+	return unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_SNDBUF, bytes)
+}
+
+func setIPv6Only(fd uintptr, enabled bool) error {
+	return unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_V6ONLY, boolint(enabled))
+}
+
+func listenBacklog(fd uintptr, n int) error {
+	return unix.Listen(int(fd), n)
+}
+
+func dupCloseOnExec(fd uintptr) (int, error) {
+	return unix.FcntlInt(fd, unix.F_DUPFD_CLOEXEC, 0)
+}
+
+func boolint(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}